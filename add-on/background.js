@@ -3,6 +3,60 @@ let port = chrome.runtime.connectNative("com.jacobweber.browser_remote");
 
 let nativeStatus = null;
 
+// Map IDs of queries we're working on to the IDs of tabs they were sent to.
+const pendingQueries = new Map();
+
+// Messages larger than this are split into chunks, since browsers only accept 1 MB from native apps.
+const MAX_MESSAGE_SIZE = 1024 * 1024;
+// Size of the data in each chunk, leaving room for the other fields after it's base64-encoded.
+const CHUNK_SIZE = (MAX_MESSAGE_SIZE - 256) / 4 * 3;
+
+let chunkCount = 0;
+const incomingChunks = new Map();
+
+const bytesToBase64 = bytes => {
+  let binary = "";
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return btoa(binary);
+};
+
+const base64ToBytes = data => Uint8Array.from(atob(data), c => c.charCodeAt(0));
+
+// Send message to native app, split into chunks if it's too large.
+const postMessage = message => {
+  const bytes = new TextEncoder().encode(JSON.stringify(message));
+  if (bytes.length <= MAX_MESSAGE_SIZE) {
+    port.postMessage(message);
+    return;
+  }
+  const id = `browser-${++chunkCount}`;
+  const count = Math.ceil(bytes.length / CHUNK_SIZE);
+  for (let index = 0; index < count; index++) {
+    const data = bytesToBase64(bytes.subarray(index * CHUNK_SIZE, (index + 1) * CHUNK_SIZE));
+    port.postMessage({ chunk: { id, index, count, data } });
+  }
+};
+
+// Add a chunk from native app, and return its whole message once every chunk has been received.
+const receiveChunk = chunk => {
+  const parts = incomingChunks.get(chunk.id) ?? [];
+  parts[chunk.index] = base64ToBytes(chunk.data);
+  incomingChunks.set(chunk.id, parts);
+  if (parts.filter(part => part).length < chunk.count) {
+    return null;
+  }
+  incomingChunks.delete(chunk.id);
+  const bytes = new Uint8Array(parts.reduce((size, part) => size + part.length, 0));
+  let offset = 0;
+  for (const part of parts) {
+    bytes.set(part, offset);
+    offset += part.length;
+  }
+  return JSON.parse(new TextDecoder().decode(bytes));
+};
+
 // Listen for messages from content scripts.
 chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
   // Popup will request status which we previously received from native app
@@ -11,8 +65,380 @@ chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
   }
 });
 
+// Find the tabs matching a selector from native app, and pass them to callback.
+const findTabs = (selector, callback) => {
+  const query = {
+    url: selector.url || ["https://*/*", "http://*/*"],
+  };
+  for (const key of ["index", "active", "currentWindow", "pinned", "audible"]) {
+    if (selector[key] !== undefined) {
+      query[key] = selector[key];
+    }
+  }
+  if (selector.windowIds?.length === 1) {
+    query.windowId = selector.windowIds[0];
+  }
+
+  let title;
+  try {
+    title = selector.title ? new RegExp(selector.title) : null;
+  } catch (err) {
+    callback([], `invalid tabs: title: ${err.message}`);
+    return;
+  }
+
+  chrome.tabs.query(query, tabs => {
+    // lastError is checked by callback
+    tabs = (tabs ?? []).filter(tab =>
+      (!selector.tabIds || selector.tabIds.includes(tab.id)) &&
+      (!selector.windowIds || selector.windowIds.includes(tab.windowId)) &&
+      (!title || title.test(tab.title ?? ""))
+    );
+    if (selector.limit) {
+      tabs = tabs.slice(0, selector.limit);
+    }
+    callback(tabs, null);
+  });
+};
+
+// Call a browser API that takes a callback, and return a promise for its result.
+const callBrowser = fn => new Promise((resolve, reject) => {
+  fn(result => {
+    if (chrome.runtime.lastError) {
+      reject(new Error(chrome.runtime.lastError.message));
+    } else {
+      resolve(result);
+    }
+  });
+});
+
+// Describe a tab for results of tab commands.
+const tabInfo = tab => ({
+  id: tab.id,
+  windowId: tab.windowId,
+  index: tab.index,
+  url: tab.url ?? "",
+  title: tab.title ?? "",
+  active: tab.active,
+  pinned: tab.pinned,
+  audible: tab.audible ?? false,
+  status: tab.status ?? "",
+});
+
+// How long the network must be quiet for a navigation waiting for "networkidle".
+const NETWORK_IDLE_TIME = 500;
+// How often to look for the element a navigation is waiting for.
+const SELECTOR_POLL_INTERVAL = 100;
+
+// Map IDs of navigations in progress to functions that stop them.
+const pendingNavigations = new Map();
+
+// Load a URL in a tab, and resolve with its final URL and HTTP status once args.waitUntil is reached.
+// The tab's content script is replaced by the new page's, so this is tracked here instead of there.
+const navigateTab = (id, tabId, args) => new Promise((resolve, reject) => {
+  const waitUntil = args.waitUntil || "load";
+  const result = { url: args.url, httpStatus: 0 };
+  const inFlightRequests = new Set();
+  let loaded = false;
+  let idleTimer = null;
+  let selectorTimer = null;
+
+  const listeners = [];
+  const listen = (event, listener, ...extra) => {
+    event.addListener(listener, ...extra);
+    listeners.push([event, listener]);
+  };
+  const finish = err => {
+    for (const [event, listener] of listeners) {
+      event.removeListener(listener);
+    }
+    clearTimeout(idleTimer);
+    clearInterval(selectorTimer);
+    pendingNavigations.delete(id);
+    err ? reject(err) : resolve(result);
+  };
+  pendingNavigations.set(id, () => finish(new Error("cancelled")));
+
+  const postProgress = progress => {
+    if (pendingQueries.has(id)) {
+      postMessage({ id, progress });
+    }
+  };
+  const isTopFrame = details => details.tabId === tabId && details.frameId === 0;
+  const checkIdle = () => {
+    clearTimeout(idleTimer);
+    if (loaded && inFlightRequests.size === 0) {
+      idleTimer = setTimeout(() => finish(), NETWORK_IDLE_TIME);
+    }
+  };
+  const pollSelector = () => {
+    const code = `document.querySelector(${JSON.stringify(args.selector)}) !== null`;
+    selectorTimer = setInterval(() => {
+      chrome.tabs.executeScript(tabId, { code }, results => {
+        // the page may not be ready for scripts yet
+        if (!chrome.runtime.lastError && results?.[0]) {
+          finish();
+        }
+      });
+    }, SELECTOR_POLL_INTERVAL);
+  };
+
+  const requestFilter = { urls: ["<all_urls>"], tabId };
+  listen(chrome.webRequest.onHeadersReceived, details => {
+    // later responses replace redirects
+    if (details.type === "main_frame") {
+      result.httpStatus = details.statusCode;
+    }
+  }, requestFilter);
+  listen(chrome.webRequest.onBeforeRequest, details => {
+    inFlightRequests.add(details.requestId);
+    checkIdle();
+  }, requestFilter);
+  for (const event of [chrome.webRequest.onCompleted, chrome.webRequest.onErrorOccurred]) {
+    listen(event, details => {
+      inFlightRequests.delete(details.requestId);
+      checkIdle();
+    }, requestFilter);
+  }
+
+  listen(chrome.webNavigation.onCommitted, details => {
+    if (isTopFrame(details)) {
+      result.url = details.url;
+      postProgress("committed");
+    }
+  });
+  listen(chrome.webNavigation.onErrorOccurred, details => {
+    if (isTopFrame(details)) {
+      finish(new Error(details.error));
+    }
+  });
+  listen(chrome.webNavigation.onDOMContentLoaded, details => {
+    if (isTopFrame(details)) {
+      result.url = details.url;
+      if (waitUntil === "domcontentloaded") {
+        finish();
+      } else if (waitUntil === "selector") {
+        pollSelector();
+      }
+    }
+  });
+  listen(chrome.webNavigation.onCompleted, details => {
+    if (isTopFrame(details)) {
+      result.url = details.url;
+      if (waitUntil === "load") {
+        finish();
+      } else if (waitUntil === "networkidle") {
+        loaded = true;
+        checkIdle();
+      }
+    }
+  });
+
+  chrome.tabs.update(tabId, { url: args.url }, () => {
+    if (chrome.runtime.lastError) {
+      finish(new Error(chrome.runtime.lastError.message));
+    } else {
+      postProgress("started");
+    }
+  });
+});
+
+// Describe a cookie from the browser's cookie store.
+const cookieInfo = cookie => ({
+  name: cookie.name,
+  value: cookie.value,
+  domain: cookie.hostOnly ? undefined : cookie.domain,
+  path: cookie.path,
+  secure: cookie.secure,
+  httpOnly: cookie.httpOnly,
+  sameSite: cookie.sameSite,
+  expirationDate: cookie.expirationDate,
+});
+
+// Find cookies that would be sent to args.url, with args.name if set.
+const findCookies = args => {
+  const details = { url: args.url };
+  if (args.name) {
+    details.name = args.name;
+  }
+  return callBrowser(callback => chrome.cookies.getAll(details, callback));
+};
+
+// Run a function in the top frame of a tab, and resolve with its result. Arguments are passed as JSON.
+const runInTab = (tabId, fn, ...args) => callBrowser(callback => chrome.tabs.executeScript(tabId, {
+  code: `(${fn})(...${JSON.stringify(args)})`,
+}, callback)).then(results => results?.[0]);
+
+// Storage areas of a tab, for storage commands.
+const storageAreas = type => type ? [type] : ["local", "session"];
+
+// Functions that run in a tab for storage commands.
+const dumpStorage = types => Object.fromEntries(types.map(type => {
+  const storage = type === "local" ? localStorage : sessionStorage;
+  const items = {};
+  for (let i = 0; i < storage.length; i++) {
+    items[storage.key(i)] = storage.getItem(storage.key(i));
+  }
+  return [type, items];
+}));
+const restoreStorage = snapshot => {
+  for (const [type, items] of Object.entries(snapshot)) {
+    const storage = type === "local" ? localStorage : sessionStorage;
+    storage.clear();
+    for (const [key, value] of Object.entries(items)) {
+      storage.setItem(key, value);
+    }
+  }
+  return true;
+};
+const clearStorage = types => {
+  for (const type of types) {
+    (type === "local" ? localStorage : sessionStorage).clear();
+  }
+  return true;
+};
+
+// Tab commands that don't use a tab selector.
+const commandsWithoutTabs = new Set(["tabs.create", "cookies.get", "cookies.set", "cookies.remove"]);
+
+// Commands that manage tabs instead of running in them. Each returns a promise for a list of results,
+// given the tabs matching the selector and the query's ID.
+const tabCommands = {
+  "tabs.list": async (args, tabs) => tabs.map(tabInfo),
+  "tabs.create": async args => {
+    const props = { url: args.url };
+    for (const key of ["windowId", "index", "active", "pinned"]) {
+      if (args[key] !== undefined) {
+        props[key] = args[key];
+      }
+    }
+    const tab = await callBrowser(callback => chrome.tabs.create(props, callback));
+    return [tabInfo(tab)];
+  },
+  "tabs.close": async (args, tabs) => {
+    await callBrowser(callback => chrome.tabs.remove(tabs.map(tab => tab.id), callback));
+    return tabs.map(tabInfo);
+  },
+  "tabs.activate": async (args, tabs) => {
+    const tab = await callBrowser(callback => chrome.tabs.update(tabs[0].id, { active: true }, callback));
+    await callBrowser(callback => chrome.windows.update(tab.windowId, { focused: true }, callback));
+    return [tabInfo(tab)];
+  },
+  "tabs.reload": async (args, tabs) => {
+    for (const tab of tabs) {
+      await callBrowser(callback => chrome.tabs.reload(tab.id, { bypassCache: args.bypassCache ?? false }, callback));
+    }
+    return tabs.map(tabInfo);
+  },
+  "tabs.move": async (args, tabs) => {
+    const props = { index: args.index };
+    if (args.windowId !== undefined) {
+      props.windowId = args.windowId;
+    }
+    const moved = await callBrowser(callback => chrome.tabs.move(tabs.map(tab => tab.id), props, callback));
+    return [].concat(moved).map(tabInfo);
+  },
+  "navigate": async (args, tabs, id) => [await navigateTab(id, tabs[0].id, args)],
+  "screenshot": async (args, tabs) => {
+    // only the active tab in a window can be captured
+    const tab = tabs[0].active ? tabs[0] : await callBrowser(callback => chrome.tabs.update(tabs[0].id, { active: true }, callback));
+    const format = args.format || "png";
+    const options = { format };
+    if (args.quality !== undefined) {
+      options.quality = args.quality;
+    }
+    const dataUrl = await callBrowser(callback => chrome.tabs.captureVisibleTab(tab.windowId, options, callback));
+    return [{ format, data: dataUrl.slice(dataUrl.indexOf(",") + 1) }];
+  },
+  "cookies.get": async args => (await findCookies(args)).map(cookieInfo),
+  "cookies.set": async args => {
+    const results = [];
+    for (const cookie of args.cookies) {
+      const details = { url: args.url, name: cookie.name, value: cookie.value ?? "" };
+      for (const key of ["domain", "path", "secure", "httpOnly", "sameSite", "expirationDate"]) {
+        if (cookie[key] !== undefined) {
+          details[key] = cookie[key];
+        }
+      }
+      results.push(cookieInfo(await callBrowser(callback => chrome.cookies.set(details, callback))));
+    }
+    return results;
+  },
+  "cookies.remove": async args => {
+    const cookies = await findCookies(args);
+    for (const cookie of cookies) {
+      // the URL must match the cookie's own domain and path to remove it
+      const url = `http${cookie.secure ? "s" : ""}://${cookie.domain.replace(/^\./, "")}${cookie.path}`;
+      await callBrowser(callback => chrome.cookies.remove({ url, name: cookie.name, storeId: cookie.storeId }, callback));
+    }
+    return cookies.map(cookieInfo);
+  },
+  "storage.get": async (args, tabs) => [await runInTab(tabs[0].id, dumpStorage, storageAreas(args.type))],
+  "storage.set": async (args, tabs) => {
+    await runInTab(tabs[0].id, restoreStorage, args);
+    return [];
+  },
+  "storage.clear": async (args, tabs) => {
+    await runInTab(tabs[0].id, clearStorage, storageAreas(args.type));
+    return [];
+  },
+};
+
+// Convert a match pattern, as described at https://developer.chrome.com/docs/extensions/develop/concepts/match-patterns,
+// to a regular expression.
+const matchPatternToRegExp = pattern => {
+  if (pattern === "<all_urls>") {
+    return /^(?:https?|wss?|ftp|file):\/\//;
+  }
+  const [, scheme, host, path] = /^(\*|[a-z]+):\/\/([^/]*)(\/.*)$/.exec(pattern);
+  const escape = text => text.replace(/[.+?^${}()|[\]\\]/g, "\\$&");
+  const schemeRegExp = scheme === "*" ? "https?|wss?" : escape(scheme);
+  let hostRegExp = escape(host).replace(/\*/g, "[^/]*");
+  if (host === "*") {
+    hostRegExp = "[^/]*";
+  } else if (host.startsWith("*.")) {
+    hostRegExp = `(?:[^/]*\\.)?${escape(host.slice(2))}`;
+  }
+  const pathRegExp = escape(path).replace(/\*/g, ".*");
+  return new RegExp(`^(?:${schemeRegExp})://${hostRegExp}${pathRegExp}$`);
+};
+
+// Find the frames of a tab matching a frame selector from native app.
+const findFrames = async (tabId, selector) => {
+  const frames = await callBrowser(callback => chrome.webNavigation.getAllFrames({ tabId }, callback)) ?? [];
+  const url = selector.url ? matchPatternToRegExp(selector.url) : null;
+  return frames.filter(frame =>
+    selector.all ||
+    (!selector.frameIds || selector.frameIds.includes(frame.frameId)) &&
+    (!url || url.test(frame.url))
+  ).sort((a, b) => a.frameId - b.frameId);
+};
+
+// Send a message to the content script of one frame, and resolve with its status, error, and value.
+const sendToFrame = (tabId, frameId, message) => new Promise(resolve => {
+  chrome.tabs.sendMessage(tabId, message, { frameId }, response => {
+    if (chrome.runtime.lastError) {
+      console.error(chrome.runtime.lastError.message);
+      resolve({ status: "error", error: chrome.runtime.lastError.message, value: null });
+    } else {
+      console.log("Received response from tab", response);
+      if (response.status !== "ok") {
+        resolve({ status: "error", error: response.status, value: null });
+      } else {
+        resolve({ status: "ok", error: undefined, value: response.result, awaited: response.awaited });
+      }
+    }
+  });
+});
+
 // Listen for messages from native app.
 port.onMessage.addListener((message) => {
+  if (message.chunk) {
+    message = receiveChunk(message.chunk);
+    if (!message) {
+      return;
+    }
+  }
   console.log("Received message from native app", message);
   if (!message.id) {
     return;
@@ -24,62 +450,138 @@ port.onMessage.addListener((message) => {
     return;
   }
 
+  // Native app dropped a message we sent, such as a response that was too large
+  if (message.id === 'error') {
+    console.error("Native app couldn't read message", message.result);
+    return;
+  }
+
+  // Native app gave up on a query; tell its tabs to stop working on it, and don't respond.
+  if (message.cancel) {
+    for (const tabId of pendingQueries.get(message.id) ?? []) {
+      chrome.tabs.sendMessage(tabId, { id: message.id, cancel: true });
+    }
+    pendingQueries.delete(message.id);
+    pendingNavigations.get(message.id)?.();
+    return;
+  }
+  pendingQueries.set(message.id, []);
+
+  const postResponse = response => {
+    if (pendingQueries.delete(message.id)) {
+      postMessage({ id: message.id, ...response });
+    }
+  };
+
   const postError = status => {
-    port.postMessage({
-      id: message.id,
+    postResponse({
       status,
       results: []
     });
   }
 
-  let query = {}
-  if (message.tabs === 'all') {
-    query = {}
-  } else { // front
-    query = {
-      currentWindow: true,
-      active: true,
-    }
+  const tabCommand = tabCommands[message.command];
+  const runTabCommand = tabs => tabCommand(message.args ?? {}, tabs, message.id).then(results => {
+    postResponse({
+      status: "ok",
+      results,
+    });
+  }, err => {
+    console.error(err.message);
+    postError(err.message);
+  });
+
+  // Commands that don't use a tab selector don't need to find tabs.
+  if (commandsWithoutTabs.has(message.command)) {
+    runTabCommand([]);
+    return;
   }
 
   // Send message to tabs, wait for their responses, and return combined response to native app.
-  chrome.tabs.query({
-    ...query,
-    url: ["https://*/*", "http://*/*"],
-  }, tabs => {
-    if (chrome.runtime.lastError) {
+  findTabs(message.tabs, (tabs, error) => {
+    if (error) {
+      console.error(error);
+      postError(error);
+    } else if (chrome.runtime.lastError) {
       console.error(chrome.runtime.lastError.message);
       postError(chrome.runtime.lastError.message);
+    } else if (message.command === "tabs.list") {
+      runTabCommand(tabs);
     } else if (tabs.length === 0) {
       postError("no tabs found");
-    } else {
-      Promise.all(tabs.map(tab => new Promise((resolve, reject) => {
-        chrome.tabs.sendMessage(tab.id, message, {}, response => {
-          if (chrome.runtime.lastError) {
-            reject(chrome.runtime.lastError.message);
-          } else {
-            console.log("Received response from tab", response);
-            if (response.status !== "ok") {
-              reject(response.status);
-            } else {
-              resolve(response.result);
-            }
-          }
-        });
-      }))).then(results => {
-        port.postMessage({
-          id: message.id,
+    } else if (tabCommand) {
+      runTabCommand(tabs);
+    } else if (pendingQueries.has(message.id)) {
+      pendingQueries.set(message.id, tabs.map(tab => tab.id));
+      // each tab's result is reported separately, so one failing tab doesn't lose the others
+      Promise.all(tabs.map(async tab => {
+        const start = performance.now();
+        const tabResult = {
+          tabId: tab.id,
+          windowId: tab.windowId,
+          url: tab.url ?? "",
+          title: tab.title ?? "",
           status: "ok",
-          results,
+          error: undefined,
+          value: null,
+        };
+        if (!message.frame) {
+          const result = await sendToFrame(tab.id, 0, message);
+          return { ...tabResult, ...result, duration: performance.now() - start };
+        }
+        try {
+          const frames = await findFrames(tab.id, message.frame);
+          tabResult.frames = await Promise.all(frames.map(async frame => {
+            const frameStart = performance.now();
+            const result = await sendToFrame(tab.id, frame.frameId, message);
+            return { frameId: frame.frameId, url: frame.url, ...result, duration: performance.now() - frameStart };
+          }));
+        } catch (err) {
+          console.error(err.message);
+          tabResult.status = "error";
+          tabResult.error = err.message;
+        }
+        return { ...tabResult, duration: performance.now() - start };
+      })).then(tabResults => {
+        postResponse({
+          status: "ok",
+          results: [],
+          tabResults,
         });
-      }).catch(error => {
-        console.error(error);
-        postError(error);
       });
     }
   });
 });
 
+// Push browser events to native app, which streams them to its clients.
+const postEvent = event => {
+  port.postMessage({ event });
+};
+
+chrome.tabs.onCreated.addListener(tab => {
+  postEvent({ type: "tab.created", tabId: tab.id, windowId: tab.windowId, url: tab.url, title: tab.title });
+});
+
+chrome.tabs.onUpdated.addListener((tabId, changes, tab) => {
+  postEvent({ type: "tab.updated", tabId, windowId: tab.windowId, url: tab.url, title: tab.title, changes });
+});
+
+chrome.tabs.onRemoved.addListener((tabId, removeInfo) => {
+  postEvent({ type: "tab.removed", tabId, windowId: removeInfo.windowId });
+});
+
+chrome.windows.onFocusChanged.addListener(windowId => {
+  // WINDOW_ID_NONE means all windows lost focus
+  postEvent({ type: "window.focused", windowId: windowId === chrome.windows.WINDOW_ID_NONE ? 0 : windowId });
+});
+
+chrome.webNavigation.onCompleted.addListener(details => {
+  // ignore navigation within frames
+  if (details.frameId === 0) {
+    postEvent({ type: "navigation.completed", tabId: details.tabId, url: details.url });
+  }
+});
+
 // Listen for the native messaging port closing.
 port.onDisconnect.addListener((port) => {
   if (port.error) {
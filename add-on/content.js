@@ -1,13 +1,252 @@
-// Evaluate message in tab context, and send back result.
+// Most elements dom.query returns, unless it specifies a limit.
+const DEFAULT_DOM_QUERY_LIMIT = 100;
+
+// Find elements matching a CSS selector or XPath expression.
+const findElements = (args, limit) => {
+  if (args.selector) {
+    return Array.from(document.querySelectorAll(args.selector)).slice(0, limit);
+  }
+  const snapshot = document.evaluate(args.xpath, document, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+  const elements = [];
+  for (let i = 0; i < snapshot.snapshotLength && elements.length < limit; i++) {
+    const node = snapshot.snapshotItem(i);
+    if (node.nodeType === Node.ELEMENT_NODE) {
+      elements.push(node);
+    }
+  }
+  return elements;
+};
+
+// Return a value that can be sent as JSON, or null.
+const toJson = value => {
+  try {
+    return JSON.parse(JSON.stringify(value)) ?? null;
+  } catch (err) {
+    return null;
+  }
+};
+
+// Describe an element for dom.query.
+const describeElement = (element, args) => {
+  const rect = element.getBoundingClientRect();
+  const style = getComputedStyle(element);
+  return {
+    tag: element.tagName.toLowerCase(),
+    text: (element.innerText ?? element.textContent ?? "").trim(),
+    attributes: Object.fromEntries((args.attributes ?? [])
+      .filter(name => element.hasAttribute(name))
+      .map(name => [name, element.getAttribute(name)])),
+    properties: Object.fromEntries((args.properties ?? []).map(name => [name, toJson(element[name])])),
+    box: { x: rect.x, y: rect.y, width: rect.width, height: rect.height },
+    visible: rect.width > 0 && rect.height > 0 && style.visibility !== "hidden" && style.display !== "none",
+  };
+};
+
+// IDs of queries that were cancelled while still running.
+const cancelledQueries = new Set();
+
+// Error for actions that can't be performed, reported in an input command's result.
+class InputError extends Error {}
+
+// Find the element for an input command, or the focused element if the selector is optional.
+const inputElement = (selector, optional) => {
+  if (!selector && optional) {
+    return document.activeElement ?? document.body;
+  }
+  const element = document.querySelector(selector);
+  if (!element) {
+    throw new InputError(`no element matches ${selector}`);
+  }
+  return element;
+};
+
+// Run an input action on an element, and return a structured result.
+const runInput = async (selector, optional, action) => {
+  let element = null;
+  try {
+    element = inputElement(selector, optional);
+    await action(element);
+    return { success: true, element: describeElement(element, {}) };
+  } catch (err) {
+    if (!(err instanceof InputError)) {
+      throw err;
+    }
+    return { success: false, error: err.message, element: element ? describeElement(element, {}) : undefined };
+  }
+};
+
+const sleep = ms => new Promise(resolve => setTimeout(resolve, ms));
+
+// Set the value of an input, using the native setter so frameworks that track it notice the change.
+const setValue = (element, value) => {
+  const prototype = element instanceof HTMLTextAreaElement ? HTMLTextAreaElement.prototype : HTMLInputElement.prototype;
+  Object.getOwnPropertyDescriptor(prototype, "value").set.call(element, value);
+};
+
+const isTextField = element => element instanceof HTMLInputElement || element instanceof HTMLTextAreaElement;
+
+const keyboardEvent = (type, key, modifiers) => new KeyboardEvent(type, {
+  key,
+  bubbles: true,
+  cancelable: true,
+  altKey: modifiers.includes("Alt"),
+  ctrlKey: modifiers.includes("Control"),
+  metaKey: modifiers.includes("Meta"),
+  shiftKey: modifiers.includes("Shift"),
+});
+
+const BUTTONS = { left: 0, middle: 1, right: 2 };
+
+// Constructor for async functions, which scripts from the run command become the body of.
+const AsyncFunction = (async () => {}).constructor;
+
+// Commands that run in a tab, given the message and its args. Each returns the tab's result, or a promise
+// for it.
+const commands = {
+  "eval": message => Function(`"use strict";return (${message.query});`)(),
+  "dom.query": (message, args) => findElements(args, args.limit || DEFAULT_DOM_QUERY_LIMIT).map(element => describeElement(element, args)),
+  "input.click": (message, args) => runInput(args.selector, false, element => {
+    if (element.disabled) {
+      throw new InputError(`${args.selector} is disabled`);
+    }
+    element.scrollIntoView({ block: "center", behavior: "instant" });
+    const rect = element.getBoundingClientRect();
+    const button = BUTTONS[args.button || "left"];
+    const init = { bubbles: true, cancelable: true, view: window, button, clientX: rect.x + rect.width / 2, clientY: rect.y + rect.height / 2 };
+    element.focus?.();
+    for (let detail = 1; detail <= (args.clickCount || 1); detail++) {
+      element.dispatchEvent(new PointerEvent("pointerdown", { ...init, detail }));
+      element.dispatchEvent(new MouseEvent("mousedown", { ...init, detail }));
+      element.dispatchEvent(new PointerEvent("pointerup", { ...init, detail }));
+      element.dispatchEvent(new MouseEvent("mouseup", { ...init, detail }));
+      element.dispatchEvent(new MouseEvent(button === 2 ? "contextmenu" : button === 1 ? "auxclick" : "click", { ...init, detail }));
+    }
+    if (args.clickCount === 2 && button === 0) {
+      element.dispatchEvent(new MouseEvent("dblclick", { ...init, detail: 2 }));
+    }
+  }),
+  "input.type": (message, args) => runInput(args.selector, true, async element => {
+    if (!isTextField(element) && !element.isContentEditable) {
+      throw new InputError(`${element.tagName.toLowerCase()} element can't be typed into`);
+    }
+    element.focus();
+    if (args.clear) {
+      isTextField(element) ? setValue(element, "") : (element.textContent = "");
+      element.dispatchEvent(new InputEvent("input", { bubbles: true, inputType: "deleteContentBackward" }));
+    }
+    for (const char of args.text ?? "") {
+      if (cancelledQueries.has(message.id)) {
+        throw new InputError("cancelled");
+      }
+      element.dispatchEvent(keyboardEvent("keydown", char, []));
+      element.dispatchEvent(keyboardEvent("keypress", char, []));
+      if (isTextField(element)) {
+        setValue(element, element.value + char);
+      } else {
+        document.execCommand("insertText", false, char);
+      }
+      element.dispatchEvent(new InputEvent("input", { bubbles: true, inputType: "insertText", data: char }));
+      element.dispatchEvent(keyboardEvent("keyup", char, []));
+      if (args.delay) {
+        await sleep(args.delay);
+      }
+    }
+    element.dispatchEvent(new Event("change", { bubbles: true }));
+  }),
+  "input.select": (message, args) => runInput(args.selector, false, element => {
+    if (!(element instanceof HTMLSelectElement)) {
+      throw new InputError(`${args.selector} is not a select element`);
+    }
+    const wanted = args.values ?? args.labels;
+    const optionKey = option => args.values ? option.value : option.label;
+    const missing = wanted.filter(want => !Array.from(element.options).some(option => optionKey(option) === want));
+    if (missing.length > 0) {
+      throw new InputError(`no options for ${missing.join(", ")}`);
+    }
+    if (wanted.length > 1 && !element.multiple) {
+      throw new InputError(`${args.selector} only allows one option`);
+    }
+    for (const option of element.options) {
+      option.selected = wanted.includes(optionKey(option));
+    }
+    element.dispatchEvent(new Event("input", { bubbles: true }));
+    element.dispatchEvent(new Event("change", { bubbles: true }));
+  }),
+  "input.press": (message, args) => runInput(args.selector, true, element => {
+    const parts = args.keys === "+" ? ["+"] : args.keys.endsWith("++") ? [...args.keys.slice(0, -2).split("+"), "+"] : args.keys.split("+");
+    const key = parts.pop();
+    if (args.selector) {
+      element.focus?.();
+    }
+    const modifiers = [];
+    for (const modifier of parts) {
+      modifiers.push(modifier);
+      element.dispatchEvent(keyboardEvent("keydown", modifier, modifiers));
+    }
+    element.dispatchEvent(keyboardEvent("keydown", key, modifiers));
+    if (key.length === 1) {
+      element.dispatchEvent(keyboardEvent("keypress", key, modifiers));
+    }
+    element.dispatchEvent(keyboardEvent("keyup", key, modifiers));
+    for (const modifier of parts.reverse()) {
+      element.dispatchEvent(keyboardEvent("keyup", modifier, modifiers));
+      modifiers.splice(modifiers.indexOf(modifier), 1);
+    }
+  }),
+  "input.scroll": (message, args) => {
+    if (!args.selector) {
+      window.scrollBy({ left: args.x ?? 0, top: args.y ?? 0, behavior: "instant" });
+      return { success: true };
+    }
+    return runInput(args.selector, false, element => {
+      element.scrollIntoView({ block: args.block || "center", behavior: "instant" });
+    });
+  },
+  "run": (message, args) => new AsyncFunction("args", `"use strict";${args.script}\n`)(args.args ?? {}),
+};
+
+// Wait for a promise to resolve, or reject if it takes longer than timeout milliseconds.
+const awaitWithTimeout = (promise, timeout) => {
+  if (!timeout) {
+    return Promise.resolve(promise);
+  }
+  let timer;
+  const timedOut = new Promise((resolve, reject) => {
+    timer = setTimeout(() => reject(new Error(`promise didn't resolve within ${timeout / 1000} seconds`)), timeout);
+  });
+  return Promise.race([promise, timedOut]).finally(() => clearTimeout(timer));
+};
+
+// Run command from message in tab context, and send back result.
 chrome.runtime.onMessage.addListener(function (message, sender, sendResponse) {
   console.log("Received message from background script:", message);
-  try {
-    const response = Function(`"use strict";return (${message.query});`)();
+  // synchronous commands are already done by the time they're cancelled, but others can stop early
+  if (message.cancel) {
+    cancelledQueries.add(message.id);
+    setTimeout(() => cancelledQueries.delete(message.id), 60000);
+    return false;
+  }
+  new Promise(resolve => {
+    const name = message.command ?? "eval";
+    const command = commands[name];
+    if (!command) {
+      throw new Error(`unknown command ${name}`);
+    }
+    // eval returns promises as they are, unless asked to wait for them, so only wait for other commands
+    const result = command(message, message.args);
+    if (name !== "eval") {
+      resolve(Promise.resolve(result).then(result => ({ result })));
+    } else if (message.awaitPromise && typeof result?.then === "function") {
+      resolve(awaitWithTimeout(result, message.awaitTimeout).then(result => ({ result, awaited: true })));
+    } else {
+      resolve({ result });
+    }
+  }).then(({ result: response, awaited }) => {
     console.log("Sending response to background script:", response);
-    // stringify may throw error on circular references
-    sendResponse({ status: "ok", result: JSON.parse(JSON.stringify(response)) });
-  } catch (err) {
+    // stringify may throw error on circular references, and returns undefined for scripts that don't return anything
+    sendResponse({ status: "ok", result: JSON.parse(JSON.stringify(response) ?? "null"), awaited });
+  }).catch(err => {
     sendResponse({ status: err.toString(), result: null });
-  }
+  });
   return true;
 });
@@ -6,7 +6,12 @@ window.addEventListener("load", () => {
       document.getElementById("success").style.display = 'none';
     } else {
       document.getElementById("address").innerText = response.address;
-      document.getElementById("address2").innerText = response.address;
+      // curl needs a different syntax for Unix domain sockets
+      document.getElementById("address2").innerText = response.address.startsWith("unix:")
+        ? `--unix-socket ${response.address.slice("unix:".length)} http://localhost`
+        : response.address;
+      document.getElementById("token").innerText = response.token;
+      document.getElementById("token2").innerText = response.token;
       document.getElementById("error").style.display = 'none';
       document.getElementById("success").style.display = 'block';
     }
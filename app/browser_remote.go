@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"github.com/jacobweber/browser_remote/internal/client"
+	"github.com/jacobweber/browser_remote/internal/config"
+	"github.com/jacobweber/browser_remote/internal/discovery"
 	"github.com/jacobweber/browser_remote/internal/logger"
 	"github.com/jacobweber/browser_remote/internal/native_messaging"
 	"github.com/jacobweber/browser_remote/internal/network"
@@ -13,26 +21,51 @@ import (
 )
 
 func main() {
+	// browsers run us with their origin or manifest path, which never matches a command
+	if len(os.Args) > 1 && client.IsCommand(os.Args[1]) {
+		os.Exit(client.Main(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	logger := logger.NewFile()
 	defer logger.Cleanup()
 
+	configPath := flag.String("config", config.DefaultPath(), "config file")
 	host := flag.String("host", "localhost", "web server hostname")
-	port := flag.Int("port", 5555, "web server port")
+	port := flag.Int("port", network.DefaultPort, "web server port")
+	socket := flag.String("socket", "", "serve on a Unix domain socket at this path, instead of a port")
+	maxTimeout := flag.Duration("max-timeout", web_server.DefaultMaxTimeout, "longest timeout a request may specify")
 	flag.Parse()
 	argv := len(os.Args)
 	if argv > 1 {
 		logger.Trace.Printf("arg: %v", os.Args[1])
 	}
 
-	openPort, ok := network.FindFreePort(logger, *host, *port, 10, true)
-	if !ok {
-		logger.Error.Printf("Unable to open port: %v:%v", *host, *port)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logger.Error.Printf("Unable to read config file %v: %v", *configPath, err)
 		return
 	}
+	token := cfg.Token
+	if token == "" {
+		token, err = web_server.GenerateToken()
+		if err != nil {
+			logger.Error.Printf("Unable to generate token: %v", err)
+			return
+		}
+	}
+
+	socketPath := *socket
+	if socketPath == "" {
+		socketPath = cfg.Socket
+	}
 
 	messageReaderFromBrowser := native_messaging.NewReader[shared.MessageFromBrowser](logger, os.Stdin, "from browser")
 	messageWriterToBrowser := native_messaging.NewWriter[shared.MessageToBrowser](logger, os.Stdout, "to browser")
 	webServer := web_server.New(logger)
+	webServer.SetMaxTimeout(*maxTimeout)
+	webServer.RequireToken(token)
+	webServer.AllowHosts(append(append([]string{*host}, web_server.LocalHosts...), cfg.AllowedHosts...))
+	webServer.AllowOrigins(cfg.AllowedOrigins)
 
 	webServer.OnMessageReadyForBrowser(func(msg shared.MessageToBrowser) {
 		messageWriterToBrowser.SendMessage(msg)
@@ -40,8 +73,50 @@ func main() {
 	messageReaderFromBrowser.OnMessageRead(func(msg shared.MessageFromBrowser) {
 		webServer.HandleMessageFromBrowser(msg)
 	})
+	messageReaderFromBrowser.OnError(func(err error) {
+		logger.Error.Printf("Dropped message from browser: %v", err)
+		// the browser can't tell it was dropped otherwise, and its request would just time out
+		if errors.Is(err, native_messaging.ErrMessageTooLarge) {
+			messageWriterToBrowser.SendMessage(shared.MessageToBrowser{
+				Id:     "error",
+				Result: err.Error(),
+			})
+		}
+	})
+
+	var listener net.Listener
+	var address string
+	if socketPath != "" {
+		listener, err = network.ListenUnix(logger, socketPath)
+		if err != nil {
+			logger.Error.Printf("Unable to open socket %v: %v", socketPath, err)
+			return
+		}
+		address = "unix:" + socketPath
+	} else {
+		listener, err = network.Listen(logger, *host, *port, network.DefaultMaxTries)
+		if err != nil {
+			logger.Error.Printf("Unable to open port: %v:%v: %v", *host, *port, err)
+			return
+		}
+		address = fmt.Sprintf("http://%v", net.JoinHostPort(*host, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)))
+	}
+	// also removes the socket file
+	defer listener.Close()
+	webServer.Serve(listener)
+
+	discoveryPath, err := discovery.Write(discovery.Dir(), discovery.Instance{
+		Address: address,
+		Pid:     os.Getpid(),
+		Browser: discovery.DetectBrowser(flag.Args()),
+		Token:   token,
+	})
+	if err != nil {
+		logger.Error.Printf("Unable to write discovery file: %v", err)
+	} else {
+		defer os.Remove(discoveryPath)
+	}
 
-	webServer.Start(*host, openPort)
 	done := make(chan bool)
 	go func() {
 		messageReaderFromBrowser.Start()
@@ -52,10 +127,18 @@ func main() {
 	messageWriterToBrowser.SendMessage(shared.MessageToBrowser{
 		Id: "status",
 		Result: map[string]any{
-			"address": fmt.Sprintf("http://%v:%v", *host, openPort),
+			"address": address,
+			"token":   token,
 		},
 	})
 
-	<-done
+	// clean up if the browser closes our input, or kills us
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-done:
+	case sig := <-signals:
+		logger.Trace.Printf("Received signal %v", sig)
+	}
 	messageWriterToBrowser.Done()
 }
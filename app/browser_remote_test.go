@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/jacobweber/browser_remote/internal/shared"
 	"github.com/jacobweber/browser_remote/internal/testing/browser_remote_tester"
+	"github.com/jacobweber/browser_remote/internal/web_server"
 )
 
 func TestApp(t *testing.T) {
@@ -46,6 +55,416 @@ func TestApp(t *testing.T) {
 		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"timeout\",\"results\":[]}\n", t)
 	})
 
+	t.Run("cancels browser query on timeout", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"query\":\"name\"}")
+		msg := <-listener
+		cancelListener := br.ListenForCancelToBrowser(msg.Id)
+		timeout.FireTimer()
+		<-cancelListener
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"timeout\",\"results\":[]}\n", t)
+	})
+
+	t.Run("responds with custom timeout error", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"query\":\"name\",\"timeout\":30}")
+		<-listener
+		timeout.FireTimerFor(30 * time.Second)
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"timeout\",\"results\":[]}\n", t)
+	})
+
+	t.Run("rejects timeouts above the maximum", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"timeout\":3600}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid timeout: must be between 0 and 300 seconds\",\"results\":[]}\n", t)
+	})
+
+	t.Run("cancels browser query when client disconnects", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		ctx, cancel := context.WithCancel(context.Background())
+		postDone, _, _ := br.SendRequestToWebWithContext(ctx, "{\"query\":\"name\"}")
+		msg := <-listener
+		cancelListener := br.ListenForCancelToBrowser(msg.Id)
+		cancel()
+		<-cancelListener
+		<-postDone
+	})
+
+	t.Run("sends tab selector to browser", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":{\"url\":\"*://*.example.com/*\",\"title\":\"^Admin\",\"limit\":1}}")
+		msg := <-listener
+		if msg.Tabs.Url != "*://*.example.com/*" || msg.Tabs.Title != "^Admin" || msg.Tabs.Limit != 1 || msg.Tabs.Active != nil {
+			t.Errorf("invalid tabs sent to browser: %v", msg.Tabs)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{"john"})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"john\"]}\n", t)
+	})
+
+	t.Run("sends front tab to browser by default", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\"}")
+		msg := <-listener
+		if msg.Tabs.Active == nil || !*msg.Tabs.Active || msg.Tabs.CurrentWindow == nil || !*msg.Tabs.CurrentWindow {
+			t.Errorf("invalid tabs sent to browser: %v", msg.Tabs)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{"john"})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"john\"]}\n", t)
+	})
+
+	t.Run("rejects invalid tab selector", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":{\"tabIds\":[-1]}}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid tabs: tabIds: -1 is negative\",\"results\":[]}\n", t)
+	})
+
+	t.Run("returns title error from browser", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":{\"title\":\"(\"}}")
+		msg := <-listener
+		br.SendResponseFromBrowser(msg.Id, "invalid tabs: title: Invalid regular expression: /(/: Unterminated group", []any{})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid tabs: title: Invalid regular expression: /(/: Unterminated group\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends command to browser", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandTabsMove)
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"tabs.move\",\"args\":{\"index\":-1},\"tabs\":{\"tabIds\":[3]}}")
+		msg := <-listener
+		if string(msg.Args) != "{\"index\":-1}" || len(msg.Tabs.TabIds) != 1 || msg.Tabs.TabIds[0] != 3 {
+			t.Errorf("invalid command sent to browser: %v", msg)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{map[string]any{"id": 3, "index": 5}})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"id\":3,\"index\":5}]}\n", t)
+	})
+
+	t.Run("sends all tabs to browser by default for tabs.list", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandTabsList)
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"tabs.list\"}")
+		msg := <-listener
+		if msg.Tabs.Active != nil || msg.Tabs.CurrentWindow != nil {
+			t.Errorf("invalid tabs sent to browser: %v", msg.Tabs)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[]}\n", t)
+	})
+
+	t.Run("rejects invalid command arguments", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"tabs.create\",\"args\":{}}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid args: url is required\",\"results\":[]}\n", t)
+	})
+
+	t.Run("waits for response after progress messages", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandNavigate)
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"waitUntil\":\"networkidle\"}}")
+		msg := <-listener
+		br.SendProgressFromBrowser(msg.Id, "started")
+		br.SendProgressFromBrowser(msg.Id, "committed")
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{shared.NavigateResult{Url: "https://example.com/home", HttpStatus: 200}})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"httpStatus\":200,\"url\":\"https://example.com/home\"}]}\n", t)
+	})
+
+	t.Run("waits longer for navigation by default", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandNavigate)
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\"}}")
+		msg := <-listener
+		cancelListener := br.ListenForCancelToBrowser(msg.Id)
+		br.SendProgressFromBrowser(msg.Id, "started")
+		timeout.FireTimerFor(30 * time.Second)
+		<-cancelListener
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"timeout\",\"results\":[]}\n", t)
+	})
+
+	t.Run("retries waitFor query until truthy", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("ready")
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"query\":\"ready\",\"waitFor\":{}}")
+		msg := <-listener
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{false})
+		timeout.FireTimerFor(100 * time.Millisecond)
+		msg = <-listener
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{"yes"})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"yes\"],\"waitFor\":{\"attempts\":2,\"elapsed\":0.1}}\n", t)
+	})
+
+	t.Run("responds with timeout error if waitFor query is never truthy", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("ready")
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"query\":\"ready\",\"timeout\":1,\"waitFor\":{\"interval\":0.25}}")
+		msg := <-listener
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{nil})
+		timeout.FireTimerFor(250 * time.Millisecond)
+		msg = <-listener
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{0})
+		timeout.FireTimerFor(250 * time.Millisecond)
+		msg = <-listener
+		cancelListener := br.ListenForCancelToBrowser(msg.Id)
+		timeout.FireTimerFor(500 * time.Millisecond)
+		<-cancelListener
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"timeout\",\"results\":[],\"waitFor\":{\"attempts\":3,\"elapsed\":1}}\n", t)
+	})
+
+	t.Run("responds with browser error from waitFor query without retrying", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("ready")
+		postDone, recorder, timeout := br.SendRequestToWeb("{\"query\":\"ready\",\"timeout\":1,\"waitFor\":{\"interval\":0.25}}")
+		msg := <-listener
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{false})
+		timeout.FireTimerFor(250 * time.Millisecond)
+		msg = <-listener
+		br.SendResponseFromBrowser(msg.Id, "ReferenceError: ready is not defined", []any{})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ReferenceError: ready is not defined\",\"results\":[],\"waitFor\":{\"attempts\":2,\"elapsed\":0.25}}\n", t)
+	})
+
+	t.Run("rejects waitFor for other commands", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"tabs.list\",\"waitFor\":{}}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid waitFor: not used by tabs.list\",\"results\":[]}\n", t)
+	})
+
+	t.Run("responds to screenshot with image", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandScreenshot)
+		getDone, recorder, _ := br.SendGetToWeb("/screenshot?tab=4&format=jpeg&quality=80")
+		msg := <-listener
+		if string(msg.Args) != "{\"format\":\"jpeg\",\"quality\":80}" || len(msg.Tabs.TabIds) != 1 || msg.Tabs.TabIds[0] != 4 {
+			t.Errorf("invalid screenshot request sent to browser: %v", msg)
+		}
+		image := bytes.Repeat([]byte{0xff, 0xd8, 0x00}, 500000)
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{shared.ScreenshotResult{Format: "jpeg", Data: base64.StdEncoding.EncodeToString(image)}})
+		<-getDone
+		resp := recorder.Result()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" || !bytes.Equal(body, image) {
+			t.Errorf("invalid screenshot response: %v %v, %v bytes", resp.StatusCode, resp.Header.Get("Content-Type"), len(body))
+		}
+	})
+
+	t.Run("responds to screenshot with browser error", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandScreenshot)
+		getDone, recorder, _ := br.SendGetToWeb("/screenshot")
+		msg := <-listener
+		br.SendResponseFromBrowser(msg.Id, "no tabs found", []any{})
+		br.AssertResponseFromWeb(getDone, recorder, "{\"status\":\"no tabs found\",\"results\":[]}\n", t)
+	})
+
+	t.Run("rejects invalid screenshot format", func(t *testing.T) {
+		getDone, recorder, _ := br.SendGetToWeb("/screenshot?format=png&quality=50")
+		br.AssertResponseFromWeb(getDone, recorder, "{\"status\":\"invalid args: quality: only used when format is \\\"jpeg\\\"\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends cookie requests to browser", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandCookiesGet)
+		getDone, recorder, _ := br.SendGetToWeb("/cookies?url=https%3A%2F%2Fexample.com%2F&name=session")
+		msg := <-listener
+		if string(msg.Args) != "{\"url\":\"https://example.com/\",\"name\":\"session\"}" {
+			t.Errorf("invalid args sent to browser: %v", string(msg.Args))
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{shared.Cookie{Name: "session", Value: "abc", Domain: "example.com", Path: "/", HttpOnly: true}})
+		br.AssertResponseFromWeb(getDone, recorder, "{\"status\":\"ok\",\"results\":[{\"domain\":\"example.com\",\"httpOnly\":true,\"name\":\"session\",\"path\":\"/\",\"value\":\"abc\"}]}\n", t)
+
+		body := "{\"url\":\"https://example.com/\",\"cookies\":[{\"name\":\"session\",\"value\":\"abc\",\"sameSite\":\"lax\"}]}"
+		listener = br.ListenForCommandToBrowser(shared.CommandCookiesSet)
+		putDone, recorder, _ := br.SendToWeb(http.MethodPut, "/cookies", body)
+		msg = <-listener
+		if string(msg.Args) != body {
+			t.Errorf("invalid args sent to browser: %v", string(msg.Args))
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{})
+		br.AssertResponseFromWeb(putDone, recorder, "{\"status\":\"ok\",\"results\":[]}\n", t)
+	})
+
+	t.Run("rejects cookie requests without URL", func(t *testing.T) {
+		deleteDone, recorder, _ := br.SendToWeb(http.MethodDelete, "/cookies?name=session", "")
+		br.AssertResponseFromWeb(deleteDone, recorder, "{\"status\":\"invalid args: url is required\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends storage requests to browser", func(t *testing.T) {
+		body := "{\"local\":{\"token\":\"abc\"},\"session\":{}}"
+		listener := br.ListenForCommandToBrowser(shared.CommandStorageSet)
+		putDone, recorder, _ := br.SendToWeb(http.MethodPut, "/storage?tab=5", body)
+		msg := <-listener
+		if string(msg.Args) != body || len(msg.Tabs.TabIds) != 1 || msg.Tabs.TabIds[0] != 5 {
+			t.Errorf("invalid storage request sent to browser: %v", msg)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{})
+		br.AssertResponseFromWeb(putDone, recorder, "{\"status\":\"ok\",\"results\":[]}\n", t)
+	})
+
+	t.Run("rejects invalid storage type", func(t *testing.T) {
+		getDone, recorder, _ := br.SendGetToWeb("/storage?type=indexed")
+		br.AssertResponseFromWeb(getDone, recorder, "{\"status\":\"invalid args: type: must be \\\"local\\\" or \\\"session\\\"\",\"results\":[]}\n", t)
+	})
+
+	t.Run("caps elements returned by dom.query", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandDomQuery)
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"dom.query\",\"args\":{\"selector\":\"li\",\"limit\":1},\"tabs\":\"all\"}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, Status: "ok", Value: []shared.Element{{Tag: "li", Text: "one"}, {Tag: "li", Text: "two"}}},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[[{\"attributes\":null,\"box\":{\"height\":0,\"width\":0,\"x\":0,\"y\":0},\"properties\":null,\"tag\":\"li\",\"text\":\"one\",\"visible\":false}]]}\n", t)
+	})
+
+	t.Run("responds with input results", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandInputClick)
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"input.click\",\"args\":{\"selector\":\"#missing\"},\"version\":2}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, WindowId: 1, Status: "ok", Value: shared.InputResult{Success: false, Error: "no element matches #missing"}},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"tabId\":1,\"windowId\":1,\"url\":\"\",\"title\":\"\",\"status\":\"ok\",\"value\":{\"error\":\"no element matches #missing\",\"success\":false},\"duration\":0}]}\n", t)
+	})
+
+	t.Run("rejects invalid key chord", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"input.press\",\"args\":{\"keys\":\"Ctrl+S\"}}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid args: keys: \\\"Ctrl\\\" is not a modifier; must be one of Alt, Control, Meta, Shift\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends frame selector to browser", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"frame\":\"*://pay.example.com/*\"}")
+		msg := <-listener
+		if msg.Frame == nil || msg.Frame.Url != "*://pay.example.com/*" {
+			t.Errorf("invalid frame sent to browser: %v", msg.Frame)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{"john"})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"john\"]}\n", t)
+	})
+
+	t.Run("responds with values of frame results", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"frame\":\"all\"}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, Status: "ok", Frames: []shared.FrameResult{
+				{FrameId: 0, Status: "ok", Value: "top"},
+				{FrameId: 5, Status: "ok", Value: "editor"},
+			}},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"top\",\"editor\"]}\n", t)
+	})
+
+	t.Run("responds with error if any frame fails", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"frame\":\"all\"}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, Status: "ok", Frames: []shared.FrameResult{
+				{FrameId: 0, Status: "ok", Value: "top"},
+				{FrameId: 5, Status: "error", Error: "ReferenceError: name is not defined"},
+			}},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ReferenceError: name is not defined\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends run requests to browser", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandRun)
+		args := "{\"script\":\"const r = await fetch(args.url);\\nreturn r.status;\",\"args\":{\"url\":\"/api\"}}"
+		postDone, recorder, _ := br.SendToWeb(http.MethodPost, "/run", "{\"script\":\"const r = await fetch(args.url);\\nreturn r.status;\",\"args\":{\"url\":\"/api\"},\"tabs\":{\"tabIds\":[3]},\"version\":2}")
+		msg := <-listener
+		if string(msg.Args) != args || msg.Tabs.TabIds[0] != 3 {
+			t.Errorf("invalid run request sent to browser: %v, %v", string(msg.Args), msg.Tabs)
+		}
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{{TabId: 3, Status: "ok", Value: 200}})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"tabId\":3,\"windowId\":0,\"url\":\"\",\"title\":\"\",\"status\":\"ok\",\"value\":200,\"duration\":0}]}\n", t)
+	})
+
+	t.Run("returns null for run scripts without a return value", func(t *testing.T) {
+		listener := br.ListenForCommandToBrowser(shared.CommandRun)
+		postDone, recorder, _ := br.SendToWeb(http.MethodPost, "/run", "{\"script\":\"document.title = 'x';\",\"tabs\":{\"tabIds\":[3]},\"version\":2}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{{TabId: 3, Status: "ok", Value: nil}})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"tabId\":3,\"windowId\":0,\"url\":\"\",\"title\":\"\",\"status\":\"ok\",\"value\":null,\"duration\":0}]}\n", t)
+	})
+
+	t.Run("rejects invalid run requests", func(t *testing.T) {
+		postDone, recorder, _ := br.SendToWeb(http.MethodPost, "/run", "{\"script\":\" \",\"args\":{\"user-id\":1}}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid args: script is required\",\"results\":[]}\n", t)
+
+		postDone, recorder, _ = br.SendToWeb(http.MethodPost, "/run", "{\"script\":\""+strings.Repeat("x", web_server.MaxRunSize+1)+"\"}")
+		br.AssertResponseFromWeb(postDone, recorder, fmt.Sprintf("{\"status\":\"invalid args: script and args must be at most %d bytes\",\"results\":[]}\n", web_server.MaxRunSize), t)
+
+		postDone, recorder, _ = br.SendToWeb(http.MethodPost, "/run", "{\"query\":\"1\"}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid JSON: json: unknown field \\\"query\\\"\",\"results\":[]}\n", t)
+	})
+
+	t.Run("sends awaitPromise to browser with time to report a timeout", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("fetch('/').then(r => r.status)")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"fetch('/').then(r => r.status)\",\"awaitPromise\":true,\"timeout\":1,\"version\":2}")
+		msg := <-listener
+		if !msg.AwaitPromise || msg.AwaitTimeout != 900 {
+			t.Errorf("invalid awaitPromise sent to browser: %v, %v", msg.AwaitPromise, msg.AwaitTimeout)
+		}
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, Status: "ok", Value: 200, Awaited: true},
+			{TabId: 2, Status: "error", Error: "Error: promise didn't resolve within 0.9 seconds"},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"tabId\":1,\"windowId\":0,\"url\":\"\",\"title\":\"\",\"status\":\"ok\",\"value\":200,\"duration\":0,\"awaited\":true},{\"tabId\":2,\"windowId\":0,\"url\":\"\",\"title\":\"\",\"status\":\"error\",\"error\":\"Error: promise didn't resolve within 0.9 seconds\",\"value\":null,\"duration\":0}]}\n", t)
+
+		listener = br.ListenForQueryToBrowser("fetch('/')")
+		postDone, recorder, _ = br.SendRequestToWeb("{\"query\":\"fetch('/')\",\"awaitPromise\":true}")
+		msg = <-listener
+		if msg.AwaitTimeout != 4500 {
+			t.Errorf("invalid default awaitTimeout sent to browser: %v", msg.AwaitTimeout)
+		}
+		br.SendResponseFromBrowser(msg.Id, "ok", []any{"done"})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"done\"]}\n", t)
+	})
+
+	t.Run("rejects awaitPromise for other commands", func(t *testing.T) {
+		postDone, recorder, _ := br.SendRequestToWeb("{\"command\":\"tabs.list\",\"awaitPromise\":true}")
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"invalid awaitPromise: not used by tabs.list\",\"results\":[]}\n", t)
+	})
+
+	t.Run("responds with frame results for version 2", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"frame\":[5],\"version\":2}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, WindowId: 1, Url: "https://a.com/", Title: "A", Status: "ok", Duration: 4, Frames: []shared.FrameResult{
+				{FrameId: 5, Url: "https://pay.a.com/", Status: "ok", Value: "editor", Duration: 3},
+			}},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[{\"tabId\":1,\"windowId\":1,\"url\":\"https://a.com/\",\"title\":\"A\",\"status\":\"ok\",\"value\":null,\"duration\":4,\"frames\":[{\"frameId\":5,\"url\":\"https://pay.a.com/\",\"status\":\"ok\",\"value\":\"editor\",\"duration\":3}]}]}\n", t)
+	})
+
+	t.Run("responds with values of tab results", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":\"all\"}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, WindowId: 1, Url: "https://a.com/", Title: "A", Status: "ok", Value: "john", Duration: 2},
+			{TabId: 2, WindowId: 1, Url: "https://b.com/", Title: "B", Status: "ok", Value: "jim", Duration: 3},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":[\"john\",\"jim\"]}\n", t)
+	})
+
+	t.Run("responds with error if any tab fails", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":\"all\"}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, WindowId: 1, Url: "https://a.com/", Title: "A", Status: "ok", Value: "john", Duration: 2},
+			{TabId: 2, WindowId: 1, Url: "https://b.com/", Title: "B", Status: "error", Error: "ReferenceError: name is not defined", Duration: 3},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ReferenceError: name is not defined\",\"results\":[]}\n", t)
+	})
+
+	t.Run("responds with tab results for version 2", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"tabs\":\"all\",\"version\":2}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "ok", []shared.TabResult{
+			{TabId: 1, WindowId: 1, Url: "https://a.com/", Title: "A", Status: "ok", Value: "john", Duration: 2},
+			{TabId: 2, WindowId: 1, Url: "https://b.com/", Title: "B", Status: "error", Error: "ReferenceError: name is not defined", Duration: 3},
+		})
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"ok\",\"results\":["+
+			"{\"tabId\":1,\"windowId\":1,\"url\":\"https://a.com/\",\"title\":\"A\",\"status\":\"ok\",\"value\":\"john\",\"duration\":2},"+
+			"{\"tabId\":2,\"windowId\":1,\"url\":\"https://b.com/\",\"title\":\"B\",\"status\":\"error\",\"error\":\"ReferenceError: name is not defined\",\"value\":null,\"duration\":3}"+
+			"]}\n", t)
+	})
+
+	t.Run("responds with browser error for version 2", func(t *testing.T) {
+		listener := br.ListenForQueryToBrowser("name")
+		postDone, recorder, _ := br.SendRequestToWeb("{\"query\":\"name\",\"version\":2}")
+		msg := <-listener
+		br.SendTabResultsFromBrowser(msg.Id, "no tabs found", nil)
+		br.AssertResponseFromWeb(postDone, recorder, "{\"status\":\"no tabs found\",\"results\":[]}\n", t)
+	})
+
 	t.Run("handles overlapping calls", func(t *testing.T) {
 		listener2 := br.ListenForQueryToBrowser("age")
 		listener1 := br.ListenForQueryToBrowser("name")
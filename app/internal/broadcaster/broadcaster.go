@@ -0,0 +1,50 @@
+package broadcaster
+
+import "sync"
+
+// Fans out published values to every current subscriber.
+type Broadcaster[T any] struct {
+	mutex       sync.RWMutex
+	subscribers map[chan T]struct{}
+	// size of each subscriber's buffer; values are dropped for subscribers that fall behind
+	bufferSize int
+}
+
+func New[T any](bufferSize int) *Broadcaster[T] {
+	return &Broadcaster[T]{
+		mutex:       sync.RWMutex{},
+		subscribers: make(map[chan T]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Returns a channel that receives published values, and a function to unsubscribe.
+func (b *Broadcaster[T]) Subscribe() (<-chan T, func()) {
+	ch := make(chan T, b.bufferSize)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[ch] = struct{}{}
+	return ch, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Sends a value to every subscriber without blocking, and returns the number of subscribers that dropped it.
+func (b *Broadcaster[T]) Publish(val T) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	dropped := 0
+	for ch := range b.subscribers {
+		select {
+		case ch <- val:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
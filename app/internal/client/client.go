@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/config"
+	"github.com/jacobweber/browser_remote/internal/discovery"
+	"github.com/jacobweber/browser_remote/internal/network"
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Sends requests to the web server of a running native app.
+type Client struct {
+	// Address of the web server, like "http://localhost:5555" or "unix:/path/to/socket".
+	Address string
+	token   string
+	baseUrl string
+	http    *http.Client
+}
+
+// Where to look for a running native app.
+type FindOptions struct {
+	// Address and token to use, instead of finding them.
+	Address string
+	Token   string
+	// PID of the instance to use, if more than one is running.
+	Pid int
+	// Directory of discovery files.
+	DiscoveryDir string
+	// Config file, whose token and socket are used if there's no discovery file.
+	ConfigPath string
+}
+
+func New(address string, token string) (*Client, error) {
+	client := &Client{Address: address, token: token, http: &http.Client{}}
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		// the host name must be one the server accepts
+		client.baseUrl = "http://localhost"
+		client.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+	} else if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		client.baseUrl = strings.TrimSuffix(address, "/")
+	} else {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	return client, nil
+}
+
+// Finds a running native app from its discovery file, or else by looking for its socket or port
+// with the settings in the config file, and returns a client for it.
+func Find(options FindOptions) (*Client, error) {
+	if options.Address != "" {
+		return New(options.Address, options.Token)
+	}
+
+	instances, err := discovery.List(options.DiscoveryDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read discovery files: %w", err)
+	}
+	for _, instance := range instances {
+		if options.Pid == 0 || instance.Pid == options.Pid {
+			token := instance.Token
+			if options.Token != "" {
+				token = options.Token
+			}
+			return New(instance.Address, token)
+		}
+	}
+	if options.Pid != 0 {
+		return nil, fmt.Errorf("no instance is running with PID %v", options.Pid)
+	}
+
+	cfg, err := config.Load(options.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %v: %w", options.ConfigPath, err)
+	}
+	token := options.Token
+	if token == "" {
+		token = cfg.Token
+	}
+	if cfg.Socket != "" {
+		return New("unix:"+cfg.Socket, token)
+	}
+	port, err := network.FindListening("localhost", network.DefaultPort, network.DefaultMaxTries)
+	if err != nil {
+		return nil, errors.New("browser_remote isn't running; make sure the browser extension is installed and enabled")
+	}
+	return New("http://"+net.JoinHostPort("localhost", strconv.Itoa(port)), token)
+}
+
+// Sends a request, waiting up to its timeout for the browser, and decodes the response into
+// response. Responses with an error status are decoded too; an error is only returned if the web
+// server couldn't be reached or its response couldn't be read.
+func (client *Client) Send(msg shared.MessageToWebServer, response any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, client.baseUrl+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return client.do(req, requestDeadline(msg), response)
+}
+
+func (client *Client) do(req *http.Request, timeout time.Duration, response any) error {
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	resp, err := client.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to reach browser_remote at %v: %w", client.Address, err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("invalid response from browser_remote (HTTP %v): %w", resp.StatusCode, err)
+	}
+	return nil
+}
+
+// Returns how long to wait for the web server to respond to a request, allowing for its own timeout.
+func requestDeadline(msg shared.MessageToWebServer) time.Duration {
+	timeout := 30 * time.Second
+	if msg.Timeout > 0 {
+		timeout = time.Duration(msg.Timeout * float64(time.Second))
+	}
+	return timeout + 5*time.Second
+}
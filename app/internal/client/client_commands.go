@@ -0,0 +1,486 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jacobweber/browser_remote/internal/config"
+	"github.com/jacobweber/browser_remote/internal/discovery"
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Exit codes of client commands.
+const (
+	ExitOk = 0
+	// The request reached the browser, but failed in some tab.
+	ExitFailed = 1
+	// The command line was invalid.
+	ExitUsage = 2
+	// The native app couldn't be reached.
+	ExitUnavailable = 3
+)
+
+// Settings shared by every client command.
+type commandContext struct {
+	stdout io.Writer
+	stderr io.Writer
+	flags  *flag.FlagSet
+	find   FindOptions
+	json   bool
+}
+
+type command struct {
+	usage       string
+	description string
+	// Whether the command sends requests to the native app, and needs flags for finding it.
+	connects bool
+	run      func(ctx *commandContext, args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"eval":      {"eval [flags] <expression>", "evaluate an expression in browser tabs", true, runEval},
+		"tabs":      {"tabs [list | open <url> | close <id>... | activate <id> | reload <id>...] [flags]", "list or manage browser tabs", true, runTabs},
+		"status":    {"status [flags]", "show whether browser_remote is running and connected", true, runStatus},
+		"run":       {"run [flags] <script.js | -> [--arg key=value]...", "run a script file in browser tabs", true, runRun},
+		"repl":      {"repl [flags]", "evaluate expressions interactively", true, runRepl},
+		"install":   {"install [flags]", "install the native messaging host manifest, so browsers can run browser_remote", false, runInstall},
+		"uninstall": {"uninstall [flags]", "remove the native messaging host manifest", false, runUninstall},
+		"doctor":    {"doctor [flags]", "check the native messaging host manifests for problems", false, runDoctor},
+		"help":      {"help", "show this help", false, runHelp},
+	}
+}
+
+// Returns whether the app was run with a client command, instead of by a browser.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Runs a client command, such as ["eval", "document.title"], and returns the exit code.
+func Main(args []string, stdout io.Writer, stderr io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		return ExitUsage
+	}
+	flags := flag.NewFlagSet("browser_remote "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	ctx := &commandContext{stdout: stdout, stderr: stderr, flags: flags}
+	if cmd.connects {
+		flags.StringVar(&ctx.find.Address, "address", "", "address of browser_remote, like http://localhost:5555 or unix:/path; found automatically by default")
+		flags.StringVar(&ctx.find.Token, "token", "", "token to authenticate with; found automatically by default")
+		flags.IntVar(&ctx.find.Pid, "pid", 0, "PID of the browser_remote to use, if more than one is running")
+		flags.StringVar(&ctx.find.ConfigPath, "config", config.DefaultPath(), "config file")
+		flags.BoolVar(&ctx.json, "json", false, "print the JSON response")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: browser_remote %v\n\n%v\n\nFlags:\n", cmd.usage, cmd.description)
+		flags.PrintDefaults()
+	}
+	ctx.find.DiscoveryDir = discovery.Dir()
+	return cmd.run(ctx, args[1:])
+}
+
+// Parses flags, which may be mixed in with positional arguments, and returns the positional ones.
+// Arguments after "--" are always positional.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positionals []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positionals, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positionals, rest...), nil
+		}
+		positionals = append(positionals, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Parses arguments, and prints usage if they're invalid.
+func (ctx *commandContext) parse(args []string, minPositionals int, maxPositionals int) ([]string, bool) {
+	positionals, err := parseArgs(ctx.flags, args)
+	if err != nil {
+		return nil, false
+	}
+	if len(positionals) < minPositionals || (maxPositionals >= 0 && len(positionals) > maxPositionals) {
+		ctx.flags.Usage()
+		return nil, false
+	}
+	return positionals, true
+}
+
+func (ctx *commandContext) fail(exitCode int, format string, args ...any) int {
+	fmt.Fprintf(ctx.stderr, "browser_remote: "+format+"\n", args...)
+	return exitCode
+}
+
+// Finds the native app, sends it a request, and returns its raw response.
+func (ctx *commandContext) send(msg shared.MessageToWebServer) (json.RawMessage, int) {
+	client, err := Find(ctx.find)
+	if err != nil {
+		return nil, ctx.fail(ExitUnavailable, "%v", err)
+	}
+	var raw json.RawMessage
+	err = client.Send(msg, &raw)
+	if err != nil {
+		return nil, ctx.fail(ExitUnavailable, "%v", err)
+	}
+	return raw, ExitOk
+}
+
+// Parses a --tabs flag, which is "front", "all", or a JSON selector.
+func parseTabs(value string) (*shared.TabSelector, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == "front" || value == "all" {
+		value = strconv.Quote(value)
+	}
+	var tabs shared.TabSelector
+	err := json.Unmarshal([]byte(value), &tabs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --tabs: %w", err)
+	}
+	return &tabs, nil
+}
+
+// Parses a --frame flag, which is "all", frame IDs separated by commas, a URL match pattern, or a
+// JSON selector.
+func parseFrame(value string) (*shared.FrameSelector, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var frame shared.FrameSelector
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		err := json.Unmarshal([]byte(value), &frame)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --frame: %w", err)
+		}
+		return &frame, nil
+	}
+	if value == "all" {
+		return &shared.FrameSelector{All: true}, nil
+	}
+	var ids []int
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return &shared.FrameSelector{Url: value}, nil
+		}
+		ids = append(ids, id)
+	}
+	return &shared.FrameSelector{FrameIds: ids}, nil
+}
+
+// Formats a value for people to read: strings as they are, and anything else as JSON.
+func formatValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Flags for commands that run in tabs.
+type targetFlags struct {
+	tabs    *string
+	frame   *string
+	timeout *float64
+}
+
+func (ctx *commandContext) targetFlags(verb string) targetFlags {
+	return targetFlags{
+		tabs:    ctx.flags.String("tabs", "", `tabs to `+verb+` in: "front" (default), "all", or a JSON selector`),
+		frame:   ctx.flags.String("frame", "", `frames to `+verb+` in: "all", frame IDs like "0,5", a URL match pattern, or a JSON selector`),
+		timeout: ctx.flags.Float64("timeout", 0, "seconds to wait for the browser"),
+	}
+}
+
+// Sets the tabs, frame, and timeout of a request from the flags.
+func (flags targetFlags) apply(msg *shared.MessageToWebServer) error {
+	var err error
+	msg.Tabs, err = parseTabs(*flags.tabs)
+	if err == nil {
+		msg.Frame, err = parseFrame(*flags.frame)
+	}
+	msg.Timeout = *flags.timeout
+	return err
+}
+
+func runEval(ctx *commandContext, args []string) int {
+	target := ctx.targetFlags("evaluate")
+	waitFor := ctx.flags.Bool("wait-for", false, "evaluate repeatedly until the expression is truthy in every tab, or the timeout expires")
+	awaitPromise := ctx.flags.Bool("await", false, "if the expression returns a promise, wait for it to resolve")
+	positionals, ok := ctx.parse(args, 1, 1)
+	if !ok {
+		return ExitUsage
+	}
+
+	msg := shared.MessageToWebServer{Query: positionals[0], Version: 2, AwaitPromise: *awaitPromise}
+	err := target.apply(&msg)
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+	if *waitFor {
+		msg.WaitFor = &shared.WaitFor{}
+	}
+	return ctx.sendForTabResults(msg)
+}
+
+// Sends a request with a version 2 response, and prints the result from each tab.
+func (ctx *commandContext) sendForTabResults(msg shared.MessageToWebServer) int {
+	raw, exitCode := ctx.send(msg)
+	if exitCode != ExitOk {
+		return exitCode
+	}
+	var response shared.MessageFromWebServerV2
+	err := json.Unmarshal(raw, &response)
+	if err != nil {
+		return ctx.fail(ExitUnavailable, "invalid response: %v", err)
+	}
+	if ctx.json {
+		fmt.Fprintf(ctx.stdout, "%s\n", raw)
+	}
+	if response.Status != "ok" {
+		return ctx.fail(ExitFailed, "%v", response.Status)
+	}
+	if ctx.json {
+		return exitCodeForResults(response.Results)
+	}
+	return ctx.printTabResults(response.Results, formatValue)
+}
+
+// Returns ExitFailed if a query failed in any tab or frame.
+func exitCodeForResults(results []shared.TabResult) int {
+	for _, tabResult := range results {
+		if tabResult.Status != "ok" {
+			return ExitFailed
+		}
+		for _, frameResult := range tabResult.Frames {
+			if frameResult.Status != "ok" {
+				return ExitFailed
+			}
+		}
+	}
+	return ExitOk
+}
+
+// Prints the value from each tab or frame with format, labelled with where it came from if there's
+// more than one, and errors to stderr.
+func (ctx *commandContext) printTabResults(results []shared.TabResult, format func(any) string) int {
+	type line struct {
+		label  string
+		status string
+		error  string
+		value  any
+	}
+	var lines []line
+	for _, tabResult := range results {
+		if tabResult.Frames == nil || tabResult.Status != "ok" {
+			lines = append(lines, line{fmt.Sprintf("tab %v", tabResult.TabId), tabResult.Status, tabResult.Error, tabResult.Value})
+			continue
+		}
+		for _, frameResult := range tabResult.Frames {
+			lines = append(lines, line{fmt.Sprintf("tab %v frame %v", tabResult.TabId, frameResult.FrameId), frameResult.Status, frameResult.Error, frameResult.Value})
+		}
+	}
+
+	exitCode := ExitOk
+	for _, line := range lines {
+		if line.status != "ok" {
+			fmt.Fprintf(ctx.stderr, "%v: error: %v\n", line.label, line.error)
+			exitCode = ExitFailed
+		} else if len(lines) == 1 {
+			fmt.Fprintln(ctx.stdout, format(line.value))
+		} else {
+			fmt.Fprintf(ctx.stdout, "%v: %v\n", line.label, format(line.value))
+		}
+	}
+	return exitCode
+}
+
+func runTabs(ctx *commandContext, args []string) int {
+	tabsFlag := ctx.flags.String("tabs", "", `tabs to list: "front", "all" (default), or a JSON selector`)
+	bypassCache := ctx.flags.Bool("bypass-cache", false, "reload without using the cache")
+	positionals, ok := ctx.parse(args, 0, -1)
+	if !ok {
+		return ExitUsage
+	}
+	action := "list"
+	if len(positionals) > 0 {
+		action = positionals[0]
+		positionals = positionals[1:]
+	}
+
+	var msg shared.MessageToWebServer
+	var err error
+	usage := func() int {
+		ctx.flags.Usage()
+		return ExitUsage
+	}
+	switch action {
+	case "list":
+		if len(positionals) > 0 {
+			return usage()
+		}
+		msg.Command = shared.CommandTabsList
+		msg.Tabs, err = parseTabs(*tabsFlag)
+	case "open":
+		if len(positionals) != 1 {
+			return usage()
+		}
+		msg.Command = shared.CommandTabsCreate
+		msg.Args, err = json.Marshal(shared.TabsCreateArgs{Url: positionals[0]})
+	case "close", "activate", "reload":
+		if len(positionals) == 0 || (action == "activate" && len(positionals) > 1) {
+			return usage()
+		}
+		msg.Command = map[string]string{
+			"close":    shared.CommandTabsClose,
+			"activate": shared.CommandTabsActivate,
+			"reload":   shared.CommandTabsReload,
+		}[action]
+		msg.Tabs, err = parseTabIds(positionals)
+		if err == nil && action == "reload" {
+			msg.Args, err = json.Marshal(shared.TabsReloadArgs{BypassCache: *bypassCache})
+		}
+	default:
+		return usage()
+	}
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+
+	raw, exitCode := ctx.send(msg)
+	if exitCode != ExitOk {
+		return exitCode
+	}
+	var response struct {
+		Status  string           `json:"status"`
+		Results []shared.TabInfo `json:"results"`
+	}
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		return ctx.fail(ExitUnavailable, "invalid response: %v", err)
+	}
+	if ctx.json {
+		fmt.Fprintf(ctx.stdout, "%s\n", raw)
+	}
+	if response.Status != "ok" {
+		return ctx.fail(ExitFailed, "%v", response.Status)
+	}
+	if !ctx.json {
+		printTabs(ctx.stdout, response.Results)
+	}
+	return ExitOk
+}
+
+// Parses tab IDs from positional arguments into a selector.
+func parseTabIds(args []string) (*shared.TabSelector, error) {
+	var ids []int
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tab ID %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return &shared.TabSelector{TabIds: ids}, nil
+}
+
+// Prints a table of tabs, with the active tab in each window marked.
+func printTabs(w io.Writer, tabs []shared.TabInfo) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tWINDOW\tTITLE\tURL")
+	for _, tab := range tabs {
+		id := strconv.Itoa(tab.Id)
+		if tab.Active {
+			id += "*"
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\n", id, tab.WindowId, tab.Title, tab.Url)
+	}
+	table.Flush()
+}
+
+func runStatus(ctx *commandContext, args []string) int {
+	_, ok := ctx.parse(args, 0, 0)
+	if !ok {
+		return ExitUsage
+	}
+	status := struct {
+		Running   bool   `json:"running"`
+		Address   string `json:"address,omitempty"`
+		Connected bool   `json:"connected"`
+		Tabs      int    `json:"tabs"`
+		Error     string `json:"error,omitempty"`
+	}{}
+
+	client, err := Find(ctx.find)
+	if err == nil {
+		status.Running = true
+		status.Address = client.Address
+		var response struct {
+			Status  string `json:"status"`
+			Results []any  `json:"results"`
+		}
+		err = client.Send(shared.MessageToWebServer{Command: shared.CommandTabsList, Timeout: 2}, &response)
+		if err == nil && response.Status != "ok" {
+			err = errors.New(response.Status)
+		}
+		status.Connected = err == nil
+		status.Tabs = len(response.Results)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	if ctx.json {
+		data, _ := json.Marshal(status)
+		fmt.Fprintf(ctx.stdout, "%s\n", data)
+	} else if !status.Running {
+		fmt.Fprintf(ctx.stdout, "not running: %v\n", status.Error)
+	} else if !status.Connected {
+		fmt.Fprintf(ctx.stdout, "running at %v, but not connected: %v\n", status.Address, status.Error)
+	} else {
+		fmt.Fprintf(ctx.stdout, "running at %v, connected to browser with %v tabs\n", status.Address, status.Tabs)
+	}
+	if !status.Connected {
+		return ExitUnavailable
+	}
+	return ExitOk
+}
+
+func runHelp(ctx *commandContext, args []string) int {
+	fmt.Fprintf(ctx.stdout, "Usage: browser_remote <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	table := tabwriter.NewWriter(ctx.stdout, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(table, "  %v\t%v\n", name, commands[name].description)
+	}
+	table.Flush()
+	fmt.Fprintf(ctx.stdout, "\nRun \"browser_remote <command> -h\" for a command's flags. Without a command, browser_remote runs as a native messaging host for the browser.\n")
+	return ExitOk
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/discovery"
+	"github.com/jacobweber/browser_remote/internal/host_manifest"
+)
+
+// Flags shared by the commands that manage host manifests.
+type manifestFlags struct {
+	browsers    *string
+	extensionId *string
+	path        *string
+}
+
+func (ctx *commandContext) manifestFlags() manifestFlags {
+	var names []string
+	for _, browser := range host_manifest.Browsers {
+		names = append(names, browser.Name)
+	}
+	return manifestFlags{
+		browsers:    ctx.flags.String("browser", "", "browsers to use, separated by commas: "+strings.Join(names, ", ")+"; defaults to each one found"),
+		extensionId: ctx.flags.String("extension-id", "", "ID of the extension allowed to run browser_remote, from chrome://extensions; required for Chromium-based browsers, which load it unpacked"),
+		path:        ctx.flags.String("path", "", "path of browser_remote; defaults to this program"),
+	}
+}
+
+// Returns the browsers named in the --browser flag, or else those that match.
+func (ctx *commandContext) selectBrowsers(flags manifestFlags, match func(host_manifest.Browser) bool) ([]host_manifest.Browser, error) {
+	var browsers []host_manifest.Browser
+	if *flags.browsers == "" {
+		for _, browser := range host_manifest.Browsers {
+			if match(browser) {
+				browsers = append(browsers, browser)
+			}
+		}
+		return browsers, nil
+	}
+	for _, name := range strings.Split(*flags.browsers, ",") {
+		browser, ok := host_manifest.FindBrowser(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown browser %q", name)
+		}
+		browsers = append(browsers, browser)
+	}
+	return browsers, nil
+}
+
+// Returns the absolute path of the app for manifests, from the --path flag or else this program.
+func appPath(flags manifestFlags) (string, error) {
+	if *flags.path != "" {
+		return filepath.Abs(*flags.path)
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+func runInstall(ctx *commandContext, args []string) int {
+	flags := ctx.manifestFlags()
+	if _, ok := ctx.parse(args, 0, 0); !ok {
+		return ExitUsage
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ctx.fail(ExitFailed, "%v", err)
+	}
+	path, err := appPath(flags)
+	if err != nil {
+		return ctx.fail(ExitFailed, "unable to find path of browser_remote: %v", err)
+	}
+	browsers, err := ctx.selectBrowsers(flags, func(browser host_manifest.Browser) bool { return browser.Detected(home) })
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+	if len(browsers) == 0 {
+		return ctx.fail(ExitUsage, "no supported browsers found; choose one with --browser")
+	}
+
+	exitCode := ExitOk
+	for _, browser := range browsers {
+		manifestPath, err := browser.Install(home, path, *flags.extensionId)
+		if errors.Is(err, host_manifest.ErrExtensionIdRequired) {
+			exitCode = ctx.fail(ExitUsage, "unable to install manifest for %v: --extension-id is required; copy the ID of the unpacked extension from chrome://extensions", browser.Name)
+			continue
+		}
+		if err != nil {
+			exitCode = ctx.fail(ExitFailed, "unable to install manifest for %v: %v", browser.Name, err)
+			continue
+		}
+		fmt.Fprintf(ctx.stdout, "%v: installed %v\n", browser.Name, manifestPath)
+	}
+	return exitCode
+}
+
+func runUninstall(ctx *commandContext, args []string) int {
+	flags := ctx.manifestFlags()
+	if _, ok := ctx.parse(args, 0, 0); !ok {
+		return ExitUsage
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ctx.fail(ExitFailed, "%v", err)
+	}
+	browsers, err := ctx.selectBrowsers(flags, func(host_manifest.Browser) bool { return true })
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+
+	exitCode := ExitOk
+	removed := false
+	for _, browser := range browsers {
+		manifestPath, err := browser.Uninstall(home)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			exitCode = ctx.fail(ExitFailed, "unable to remove manifest for %v: %v", browser.Name, err)
+			continue
+		}
+		removed = true
+		fmt.Fprintf(ctx.stdout, "%v: removed %v\n", browser.Name, manifestPath)
+	}
+	if !removed && exitCode == ExitOk {
+		fmt.Fprintln(ctx.stdout, "no manifests were installed")
+	}
+	return exitCode
+}
+
+func runDoctor(ctx *commandContext, args []string) int {
+	flags := ctx.manifestFlags()
+	if _, ok := ctx.parse(args, 0, 0); !ok {
+		return ExitUsage
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ctx.fail(ExitFailed, "%v", err)
+	}
+	path, err := appPath(flags)
+	if err != nil {
+		return ctx.fail(ExitFailed, "unable to find path of browser_remote: %v", err)
+	}
+	explicit := *flags.browsers != ""
+	browsers, err := ctx.selectBrowsers(flags, func(host_manifest.Browser) bool { return true })
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+
+	exitCode := ExitOk
+	installed := false
+	for _, browser := range browsers {
+		problems, err := browser.Check(home, path, *flags.extensionId)
+		if errors.Is(err, fs.ErrNotExist) {
+			// only mention missing manifests for browsers the user has
+			if explicit || browser.Detected(home) {
+				fmt.Fprintf(ctx.stdout, "%v: not installed; run \"browser_remote install --browser %v\"\n", browser.Name, browser.Name)
+				exitCode = ExitFailed
+			}
+			continue
+		}
+		installed = true
+		if err != nil {
+			fmt.Fprintf(ctx.stdout, "%v: unable to read %v: %v\n", browser.Name, browser.ManifestPath(home), err)
+			exitCode = ExitFailed
+			continue
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(ctx.stdout, "%v: ok (%v)\n", browser.Name, browser.ManifestPath(home))
+			continue
+		}
+		fmt.Fprintf(ctx.stdout, "%v: problems with %v; run \"browser_remote install --browser %v\" to fix them:\n", browser.Name, browser.ManifestPath(home), browser.Name)
+		for _, problem := range problems {
+			fmt.Fprintf(ctx.stdout, "  %v\n", problem)
+		}
+		exitCode = ExitFailed
+	}
+	if !installed && !explicit {
+		fmt.Fprintln(ctx.stdout, "no manifests are installed; run \"browser_remote install\"")
+		exitCode = ExitFailed
+	}
+
+	instances, err := discovery.List(ctx.find.DiscoveryDir)
+	if err == nil {
+		for _, instance := range instances {
+			fmt.Fprintf(ctx.stdout, "running in %v (PID %v) at %v\n", instance.Browser, instance.Pid, instance.Address)
+		}
+	}
+	return exitCode
+}
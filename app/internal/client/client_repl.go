@@ -0,0 +1,452 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterh/liner"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Reads lines of input for the REPL.
+type lineReader interface {
+	// Returns the next line, io.EOF at the end of input, or liner.ErrPromptAborted if the user
+	// cancelled the line.
+	Prompt(prompt string) (string, error)
+	AppendHistory(item string)
+}
+
+// State of an interactive session, which meta-commands change.
+type repl struct {
+	ctx    *commandContext
+	client *Client
+	tabs   shared.TabSelector
+	// Tabs are further filtered by this, if a URL was given that isn't a match pattern.
+	urlGlob *regexp.Regexp
+	frame   *shared.FrameSelector
+	// Seconds to wait for each query, or 0 for the server's default.
+	timeout float64
+}
+
+var metaCommands = []struct {
+	name  string
+	usage string
+}{
+	{".tabs", ".tabs [front | all | <JSON selector>]  list the target tabs, or change them"},
+	{".target", ".target [url=<pattern>] [title=<regexp>] [id=<id>,...] [window=<id>] [active] [pinned] [audible]  target the tabs matching all of these"},
+	{".frame", ".frame [top | all | <frame IDs> | <URL pattern>]  show or change the target frames"},
+	{".timeout", ".timeout [30s | default]  show or change the timeout"},
+	{".help", ".help  show this help"},
+	{".exit", ".exit  exit (or press Ctrl-D)"},
+}
+
+func runRepl(ctx *commandContext, args []string) int {
+	defaultHistory := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		defaultHistory = filepath.Join(dir, "browser_remote", "repl_history")
+	}
+	historyPath := ctx.flags.String("history", defaultHistory, `file to save input history in, or "" for none`)
+	if _, ok := ctx.parse(args, 0, 0); !ok {
+		return ExitUsage
+	}
+	client, err := Find(ctx.find)
+	if err != nil {
+		return ctx.fail(ExitUnavailable, "%v", err)
+	}
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+	line.SetCompleter(func(input string) []string {
+		var matches []string
+		for _, meta := range metaCommands {
+			if strings.HasPrefix(meta.name, input) {
+				matches = append(matches, meta.name)
+			}
+		}
+		return matches
+	})
+	reader := &historyLiner{State: line}
+	if *historyPath != "" {
+		// a missing or unreadable file just starts a new history
+		entries, _ := readHistory(*historyPath)
+		for _, entry := range entries {
+			reader.AppendHistory(entry)
+		}
+	}
+
+	fmt.Fprintf(ctx.stdout, "Connected to %v. Enter JavaScript expressions, or .help for commands.\n", client.Address)
+	r := &repl{ctx: ctx, client: client, tabs: shared.FrontTabs()}
+	r.run(reader)
+
+	if *historyPath != "" {
+		err = writeHistory(*historyPath, reader.history)
+		if err != nil {
+			ctx.fail(ExitFailed, "unable to save history: %v", err)
+		}
+	}
+	return ExitOk
+}
+
+// Reads and evaluates input until it ends, or the user exits.
+func (r *repl) run(reader lineReader) {
+	var lines []string
+	for {
+		prompt := "> "
+		if len(lines) > 0 {
+			prompt = "... "
+		}
+		line, err := reader.Prompt(prompt)
+		if errors.Is(err, liner.ErrPromptAborted) {
+			lines = nil
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				r.ctx.fail(ExitFailed, "%v", err)
+			}
+			return
+		}
+		if len(lines) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		if len(lines) == 1 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			reader.AppendHistory(line)
+			lines = nil
+			if !r.runMeta(strings.TrimSpace(line)) {
+				return
+			}
+			continue
+		}
+		if isIncomplete(input) {
+			continue
+		}
+		// recalling the entry brings back every line
+		reader.AppendHistory(input)
+		lines = nil
+		r.eval(input)
+	}
+}
+
+// Reads lines with liner, and keeps the history so it can be saved with multi-line entries intact,
+// which liner.State.WriteHistory would split into several.
+type historyLiner struct {
+	*liner.State
+	history []string
+}
+
+func (l *historyLiner) AppendHistory(item string) {
+	// like liner, skip repeated entries and keep the most recent ones
+	if len(l.history) > 0 && l.history[len(l.history)-1] == item {
+		return
+	}
+	l.history = append(l.history, item)
+	if len(l.history) > liner.HistoryLimit {
+		l.history = l.history[1:]
+	}
+	l.State.AppendHistory(item)
+}
+
+// Reads a history file, which has an entry on each line, quoted if it has several lines.
+func readHistory(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, `"`) {
+			if entry, err := strconv.Unquote(line); err == nil {
+				line = entry
+			}
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// Writes a history file that readHistory can read.
+func writeHistory(path string, entries []string) error {
+	var data []byte
+	for _, entry := range entries {
+		if strings.Contains(entry, "\n") || strings.HasPrefix(entry, `"`) {
+			entry = strconv.Quote(entry)
+		}
+		data = append(data, entry+"\n"...)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Returns whether JavaScript code has unclosed brackets, strings, or comments, or ends with a
+// backslash, so more lines are needed. Regular expression literals aren't recognized.
+func isIncomplete(code string) bool {
+	var closers []rune
+	runes := []rune(code)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '(':
+			closers = append(closers, ')')
+		case '[':
+			closers = append(closers, ']')
+		case '{':
+			closers = append(closers, '}')
+		case ')', ']', '}':
+			// let the browser report mismatched brackets
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return false
+			}
+			closers = closers[:len(closers)-1]
+		case '"', '\'', '`':
+			i++
+			for ; i < len(runes) && runes[i] != c; i++ {
+				if runes[i] == '\\' {
+					i++
+				} else if runes[i] == '\n' && c != '`' {
+					return false
+				}
+			}
+			if i >= len(runes) {
+				return true
+			}
+		case '/':
+			if i+1 < len(runes) && runes[i+1] == '/' {
+				for i < len(runes) && runes[i] != '\n' {
+					i++
+				}
+			} else if i+1 < len(runes) && runes[i+1] == '*' {
+				for i += 2; i+1 < len(runes) && (runes[i] != '*' || runes[i+1] != '/'); i++ {
+				}
+				if i+1 >= len(runes) {
+					return true
+				}
+				i++
+			}
+		}
+	}
+	return len(closers) > 0 || strings.HasSuffix(strings.TrimRight(code, " \t"), "\\")
+}
+
+// Runs a meta-command, and returns false if the session should end.
+func (r *repl) runMeta(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	var err error
+	switch name {
+	case ".tabs":
+		if arg != "" {
+			var tabs *shared.TabSelector
+			tabs, err = parseTabs(arg)
+			if err == nil {
+				r.tabs = *tabs
+				r.urlGlob = nil
+			}
+		}
+		if err == nil {
+			err = r.printTabs()
+		}
+	case ".target":
+		if arg != "" {
+			err = r.setTarget(strings.Fields(arg))
+		}
+		if err == nil {
+			err = r.printTabs()
+		}
+	case ".frame":
+		if arg == "top" {
+			r.frame = nil
+		} else if arg != "" {
+			r.frame, err = parseFrame(arg)
+		}
+		if err == nil {
+			data, _ := json.Marshal(r.frame)
+			fmt.Fprintf(r.ctx.stdout, "frame: %s\n", data)
+		}
+	case ".timeout":
+		if arg == "default" {
+			r.timeout = 0
+		} else if arg != "" {
+			r.timeout, err = parseTimeout(arg)
+		}
+		if err == nil && r.timeout == 0 {
+			fmt.Fprintln(r.ctx.stdout, "timeout: default")
+		} else if err == nil {
+			fmt.Fprintf(r.ctx.stdout, "timeout: %vs\n", r.timeout)
+		}
+	case ".help":
+		for _, meta := range metaCommands {
+			fmt.Fprintln(r.ctx.stdout, meta.usage)
+		}
+	case ".exit", ".quit":
+		return false
+	default:
+		err = fmt.Errorf("unknown command %v; enter .help for commands", name)
+	}
+	if err != nil {
+		fmt.Fprintf(r.ctx.stderr, "error: %v\n", err)
+	}
+	return true
+}
+
+// Parses a timeout like "30s", or a number of seconds.
+func parseTimeout(value string) (float64, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return seconds, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return duration.Seconds(), nil
+}
+
+// Targets the tabs matching every criterion, like "url=*github*" or "active".
+func (r *repl) setTarget(criteria []string) error {
+	var tabs shared.TabSelector
+	var urlGlob *regexp.Regexp
+	yes := true
+	for _, criterion := range criteria {
+		key, value, _ := strings.Cut(criterion, "=")
+		var err error
+		switch key {
+		case "url":
+			if strings.Contains(value, "://") || value == "<all_urls>" {
+				tabs.Url = value
+			} else {
+				// match patterns need a scheme and host, so match anything else against the whole URL
+				urlGlob = regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(value), `\*`, ".*") + "$")
+			}
+		case "title":
+			// the browser reports it if it isn't a valid regular expression
+			tabs.Title = value
+		case "id":
+			var selector *shared.TabSelector
+			selector, err = parseTabIds(strings.Split(value, ","))
+			if err == nil {
+				tabs.TabIds = selector.TabIds
+			}
+		case "window":
+			var id int
+			id, err = strconv.Atoi(value)
+			tabs.WindowIds = append(tabs.WindowIds, id)
+		case "active":
+			tabs.Active = &yes
+		case "pinned":
+			tabs.Pinned = &yes
+		case "audible":
+			tabs.Audible = &yes
+		default:
+			err = errors.New("unknown criterion")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid target %q: %w", criterion, err)
+		}
+	}
+	r.tabs = tabs
+	r.urlGlob = urlGlob
+	return nil
+}
+
+// Returns the tabs that are currently targeted.
+func (r *repl) listTabs() ([]shared.TabInfo, error) {
+	var response struct {
+		Status  string           `json:"status"`
+		Results []shared.TabInfo `json:"results"`
+	}
+	err := r.client.Send(shared.MessageToWebServer{Command: shared.CommandTabsList, Tabs: &r.tabs, Timeout: r.timeout}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Status != "ok" {
+		return nil, errors.New(response.Status)
+	}
+	if r.urlGlob == nil {
+		return response.Results, nil
+	}
+	return slices.DeleteFunc(response.Results, func(tab shared.TabInfo) bool { return !r.urlGlob.MatchString(tab.Url) }), nil
+}
+
+func (r *repl) printTabs() error {
+	tabs, err := r.listTabs()
+	if err != nil {
+		return err
+	}
+	if r.ctx.json {
+		data, _ := json.Marshal(tabs)
+		fmt.Fprintf(r.ctx.stdout, "%s\n", data)
+		return nil
+	}
+	printTabs(r.ctx.stdout, tabs)
+	return nil
+}
+
+// Evaluates an expression in the targeted tabs, and prints the results, or the response with --json.
+func (r *repl) eval(query string) {
+	tabs := &r.tabs
+	if r.urlGlob != nil {
+		matching, err := r.listTabs()
+		if err == nil && len(matching) == 0 {
+			err = errors.New("no tabs match the target")
+		}
+		if err != nil {
+			fmt.Fprintf(r.ctx.stderr, "error: %v\n", err)
+			return
+		}
+		tabs = &shared.TabSelector{}
+		for _, tab := range matching {
+			tabs.TabIds = append(tabs.TabIds, tab.Id)
+		}
+	}
+
+	var raw json.RawMessage
+	var response shared.MessageFromWebServerV2
+	err := r.client.Send(shared.MessageToWebServer{Query: query, Tabs: tabs, Frame: r.frame, Timeout: r.timeout, Version: 2, AwaitPromise: true}, &raw)
+	if err == nil {
+		err = json.Unmarshal(raw, &response)
+	}
+	if err == nil && r.ctx.json {
+		fmt.Fprintf(r.ctx.stdout, "%s\n", raw)
+		return
+	}
+	if err == nil && response.Status != "ok" {
+		err = errors.New(response.Status)
+	}
+	if err != nil {
+		fmt.Fprintf(r.ctx.stderr, "error: %v\n", err)
+		return
+	}
+	if len(response.Results) == 0 {
+		fmt.Fprintln(r.ctx.stderr, "error: no tabs match the target")
+		return
+	}
+	r.ctx.printTabResults(response.Results, prettyValue)
+}
+
+// Formats a value as indented JSON.
+func prettyValue(value any) string {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Values from repeated --arg and --arg-json flags.
+type scriptArgs struct {
+	values map[string]any
+	// Whether values are JSON, instead of strings.
+	json bool
+}
+
+func (args scriptArgs) String() string {
+	return ""
+}
+
+func (args scriptArgs) Set(flag string) error {
+	key, value, ok := strings.Cut(flag, "=")
+	if !ok || key == "" {
+		return errors.New("must be key=value")
+	}
+	if !args.json {
+		args.values[key] = value
+		return nil
+	}
+	var parsed any
+	err := json.Unmarshal([]byte(value), &parsed)
+	if err != nil {
+		return fmt.Errorf("invalid JSON for %v: %w", key, err)
+	}
+	args.values[key] = parsed
+	return nil
+}
+
+func runRun(ctx *commandContext, args []string) int {
+	target := ctx.targetFlags("run")
+	values := map[string]any{}
+	ctx.flags.Var(scriptArgs{values: values}, "arg", "pass key=value to the script as args.key; may be repeated")
+	ctx.flags.Var(scriptArgs{values: values, json: true}, "arg-json", "pass key=<JSON value> to the script as args.key; may be repeated")
+	positionals, ok := ctx.parse(args, 1, 1)
+	if !ok {
+		return ExitUsage
+	}
+
+	var script []byte
+	var err error
+	if positionals[0] == "-" {
+		script, err = io.ReadAll(os.Stdin)
+	} else {
+		script, err = os.ReadFile(positionals[0])
+	}
+	if err != nil {
+		return ctx.fail(ExitUsage, "unable to read script: %v", err)
+	}
+
+	msg := shared.MessageToWebServer{Command: shared.CommandRun, Version: 2}
+	err = target.apply(&msg)
+	if err == nil {
+		msg.Args, err = json.Marshal(shared.RunArgs{Script: string(script), Args: values})
+	}
+	if err != nil {
+		return ctx.fail(ExitUsage, "%v", err)
+	}
+	return ctx.sendForTabResults(msg)
+}
@@ -0,0 +1,414 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jacobweber/browser_remote/internal/discovery"
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		positionals []string
+		json        bool
+	}{
+		{[]string{"document.title"}, []string{"document.title"}, false},
+		{[]string{"--json", "document.title"}, []string{"document.title"}, true},
+		{[]string{"document.title", "--json"}, []string{"document.title"}, true},
+		{[]string{"open", "--json", "https://example.com"}, []string{"open", "https://example.com"}, true},
+		{[]string{"--", "-1", "--json"}, []string{"-1", "--json"}, false},
+		{[]string{"a", "--", "--json"}, []string{"a", "--json"}, false},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		json := flags.Bool("json", false, "")
+		positionals, err := parseArgs(flags, test.args)
+		if err != nil || !reflect.DeepEqual(positionals, test.positionals) || *json != test.json {
+			t.Errorf("%v: expected %v, %v, got %v, %v, %v", test.args, test.positionals, test.json, positionals, *json, err)
+		}
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := map[string]shared.FrameSelector{
+		"all":                   {All: true},
+		"0,5":                   {FrameIds: []int{0, 5}},
+		"*://*.example.com/*":   {Url: "*://*.example.com/*"},
+		`{"url": "https://*/"}`: {Url: "https://*/"},
+	}
+	for value, expected := range tests {
+		frame, err := parseFrame(value)
+		if err != nil || !reflect.DeepEqual(*frame, expected) {
+			t.Errorf("%v: expected %+v, got %+v, %v", value, expected, frame, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	discovery.Write(dir, discovery.Instance{Address: "unix:/tmp/browser_remote.sock", Pid: os.Getpid(), Token: "secret"})
+
+	client, err := Find(FindOptions{DiscoveryDir: dir})
+	if err != nil || client.Address != "unix:/tmp/browser_remote.sock" || client.token != "secret" {
+		t.Errorf("expected instance from discovery file, got %+v, %v", client, err)
+	}
+	client, err = Find(FindOptions{DiscoveryDir: dir, Token: "other"})
+	if err != nil || client.token != "other" {
+		t.Errorf("expected token to be overridden, got %+v, %v", client, err)
+	}
+	if _, err := Find(FindOptions{DiscoveryDir: dir, Pid: os.Getpid() + 1}); err == nil {
+		t.Errorf("expected error for PID that isn't running")
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	os.WriteFile(configPath, []byte(`{"token": "fromConfig", "socket": "/tmp/other.sock"}`), 0600)
+	client, err = Find(FindOptions{DiscoveryDir: filepath.Join(dir, "missing"), ConfigPath: configPath})
+	if err != nil || client.Address != "unix:/tmp/other.sock" || client.token != "fromConfig" {
+		t.Errorf("expected socket from config, got %+v, %v", client, err)
+	}
+
+	if _, err := Find(FindOptions{Address: "localhost:5555"}); err == nil {
+		t.Errorf("expected error for address without scheme")
+	}
+}
+
+// Starts a web server that checks the token, and responds to each request with the next response.
+func startServer(t *testing.T, requests *[]shared.MessageToWebServer, responses ...string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"status": "unauthorized", "results": []}`, http.StatusUnauthorized)
+			return
+		}
+		var msg shared.MessageToWebServer
+		json.NewDecoder(req.Body).Decode(&msg)
+		*requests = append(*requests, msg)
+		io.WriteString(w, responses[0])
+		responses = responses[1:]
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func runMain(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	exitCode := Main(args, &stdout, &stderr)
+	return exitCode, stdout.String(), stderr.String()
+}
+
+func TestEval(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": "Example"}]}`,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": {"a": 1}}, {"tabId": 2, "status": "error", "error": "ReferenceError"}]}`,
+		`{"status": "timed out", "results": []}`,
+	)
+
+	exitCode, stdout, stderr := runMain("eval", "--address", address, "--token", "secret", "document.title", "--tabs", "all", "--timeout", "2")
+	if exitCode != ExitOk || stdout != "Example\n" || stderr != "" {
+		t.Errorf("unexpected output: %v, %q, %q", exitCode, stdout, stderr)
+	}
+	all := shared.AllTabs()
+	expected := shared.MessageToWebServer{Query: "document.title", Args: json.RawMessage("null"), Tabs: &all, Timeout: 2, Version: 2}
+	if !reflect.DeepEqual(requests[0], expected) {
+		t.Errorf("expected request %+v, got %+v", expected, requests[0])
+	}
+
+	exitCode, stdout, stderr = runMain("eval", "--address", address, "--token", "secret", "x", "--await")
+	if exitCode != ExitFailed || stdout != "tab 1: {\"a\":1}\n" || stderr != "tab 2: error: ReferenceError\n" {
+		t.Errorf("unexpected output: %v, %q, %q", exitCode, stdout, stderr)
+	}
+
+	if !requests[1].AwaitPromise {
+		t.Errorf("expected request to await promise, got %+v", requests[1])
+	}
+
+	exitCode, stdout, _ = runMain("eval", "--address", address, "--token", "secret", "--json", "x")
+	if exitCode != ExitFailed || !strings.Contains(stdout, `"timed out"`) {
+		t.Errorf("unexpected output: %v, %q", exitCode, stdout)
+	}
+
+	if exitCode, _, _ := runMain("eval", "--address", address, "--token", "wrong", "x"); exitCode != ExitFailed {
+		t.Errorf("expected failure for wrong token, got %v", exitCode)
+	}
+	if exitCode, _, _ := runMain("eval", "--address", address); exitCode != ExitUsage {
+		t.Errorf("expected usage error without expression, got %v", exitCode)
+	}
+	if exitCode, _, _ := runMain("eval", "--tabs", "[", "x"); exitCode != ExitUsage {
+		t.Errorf("expected usage error for invalid tabs, got %v", exitCode)
+	}
+}
+
+func TestTabs(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests,
+		`{"status": "ok", "results": [{"id": 1, "windowId": 2, "title": "Example", "url": "https://example.com/", "active": true}]}`,
+		`{"status": "ok", "results": []}`,
+	)
+
+	exitCode, stdout, _ := runMain("tabs", "--address", address, "--token", "secret")
+	if exitCode != ExitOk || !strings.Contains(stdout, "1*  2       Example  https://example.com/") {
+		t.Errorf("unexpected output: %v, %q", exitCode, stdout)
+	}
+	if requests[0].Command != shared.CommandTabsList {
+		t.Errorf("expected tabs.list, got %+v", requests[0])
+	}
+
+	exitCode, _, _ = runMain("tabs", "close", "3", "4", "--address", address, "--token", "secret")
+	expected := shared.MessageToWebServer{Command: shared.CommandTabsClose, Args: json.RawMessage("null"), Tabs: &shared.TabSelector{TabIds: []int{3, 4}}}
+	if exitCode != ExitOk || !reflect.DeepEqual(requests[1], expected) {
+		t.Errorf("expected request %+v, got %v, %+v", expected, exitCode, requests[1])
+	}
+
+	if exitCode, _, _ := runMain("tabs", "close", "front"); exitCode != ExitUsage {
+		t.Errorf("expected usage error for invalid tab ID, got %v", exitCode)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests, `{"status": "ok", "results": [{"id": 1}, {"id": 2}]}`)
+
+	exitCode, stdout, _ := runMain("status", "--address", address, "--token", "secret")
+	if exitCode != ExitOk || stdout != "running at "+address+", connected to browser with 2 tabs\n" {
+		t.Errorf("unexpected output: %v, %q", exitCode, stdout)
+	}
+
+	exitCode, stdout, _ = runMain("status", "--address", "unix:"+filepath.Join(t.TempDir(), "missing.sock"), "--json")
+	if exitCode != ExitUnavailable || !strings.Contains(stdout, `"connected":false`) {
+		t.Errorf("unexpected output: %v, %q", exitCode, stdout)
+	}
+}
+
+func TestInstallCommands(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	// doctor lists running instances, and removes stale discovery files
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	app := filepath.Join(home, "browser_remote")
+	os.WriteFile(app, []byte{}, 0755)
+
+	if exitCode, _, _ := runMain("install", "--path", app); exitCode != ExitUsage {
+		t.Errorf("expected usage error without any browsers, got %v", exitCode)
+	}
+	if exitCode, _, _ := runMain("install", "--browser", "netscape"); exitCode != ExitUsage {
+		t.Errorf("expected usage error for unknown browser, got %v", exitCode)
+	}
+
+	os.MkdirAll(filepath.Join(home, ".mozilla"), 0755)
+	exitCode, stdout, _ := runMain("install", "--path", app)
+	if exitCode != ExitOk || stdout != "firefox: installed "+filepath.Join(home, ".mozilla/native-messaging-hosts/com.jacobweber.browser_remote.json")+"\n" {
+		t.Errorf("expected firefox manifest to be installed, got %v, %q", exitCode, stdout)
+	}
+	exitCode, _, stderr := runMain("install", "--path", app, "--browser", "chrome")
+	if exitCode != ExitUsage || !strings.Contains(stderr, "--extension-id is required") {
+		t.Errorf("expected usage error for chrome without extension ID, got %v, %q", exitCode, stderr)
+	}
+	exitCode, stdout, _ = runMain("install", "--path", app, "--browser", "chrome,brave", "--extension-id", "jgmdchjaeklnmaikghgeiodkegiiedge")
+	if exitCode != ExitOk || !strings.HasPrefix(stdout, "chrome: installed") || !strings.Contains(stdout, "\nbrave: installed") {
+		t.Errorf("expected chrome and brave manifests to be installed, got %v, %q", exitCode, stdout)
+	}
+
+	exitCode, stdout, _ = runMain("doctor", "--path", app)
+	if exitCode != ExitOk || strings.Count(stdout, ": ok") != 3 {
+		t.Errorf("expected manifests to be ok, got %v, %q", exitCode, stdout)
+	}
+	exitCode, stdout, _ = runMain("doctor", "--path", filepath.Join(home, "moved"))
+	if exitCode != ExitFailed || !strings.Contains(stdout, "path is "+app+" instead of") {
+		t.Errorf("expected stale path to be reported, got %v, %q", exitCode, stdout)
+	}
+
+	exitCode, stdout, _ = runMain("uninstall", "--browser", "brave")
+	if exitCode != ExitOk || !strings.HasPrefix(stdout, "brave: removed") {
+		t.Errorf("expected brave manifest to be removed, got %v, %q", exitCode, stdout)
+	}
+	exitCode, stdout, _ = runMain("doctor", "--path", app)
+	if exitCode != ExitFailed || !strings.Contains(stdout, "brave: not installed") {
+		t.Errorf("expected missing brave manifest to be reported, got %v, %q", exitCode, stdout)
+	}
+	runMain("uninstall")
+	exitCode, stdout, _ = runMain("doctor", "--path", app, "--browser", "chromium")
+	if exitCode != ExitFailed || !strings.HasPrefix(stdout, "chromium: not installed") {
+		t.Errorf("expected all manifests to be removed, got %v, %q", exitCode, stdout)
+	}
+}
+
+func TestIsIncomplete(t *testing.T) {
+	tests := map[string]bool{
+		"document.title":                    false,
+		"[1, 2,":                            true,
+		"({\n  a: 1\n})":                    false,
+		"(() => {\n  return ')'":            true,
+		"`line\n":                           true,
+		"'unterminated\n":                   false,
+		"1 + // comment (\n":                false,
+		"1 + /* comment":                    true,
+		"1 + /* ( */ 2":                     false,
+		"'a' + \\":                          true,
+		"fn())":                             false,
+		`"escaped \" quote"`:                false,
+		"[`${document.title}`, \"}\", '{']": false,
+	}
+	for code, expected := range tests {
+		if incomplete := isIncomplete(code); incomplete != expected {
+			t.Errorf("%q: expected incomplete %v, got %v", code, expected, incomplete)
+		}
+	}
+}
+
+type fakeLineReader struct {
+	lines   []string
+	history []string
+}
+
+func (reader *fakeLineReader) Prompt(prompt string) (string, error) {
+	if len(reader.lines) == 0 {
+		return "", io.EOF
+	}
+	line := reader.lines[0]
+	reader.lines = reader.lines[1:]
+	// like pressing the up arrow
+	if line == "<up>" && len(reader.history) > 0 {
+		line = reader.history[len(reader.history)-1]
+	}
+	return line, nil
+}
+
+func (reader *fakeLineReader) AppendHistory(item string) {
+	reader.history = append(reader.history, item)
+}
+
+func TestRepl(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": {"a": [1]}}]}`,
+		`{"status": "ok", "results": [{"id": 1, "url": "https://github.com/"}, {"id": 2, "url": "https://example.com/"}]}`,
+		`{"status": "ok", "results": [{"id": 1, "url": "https://github.com/"}, {"id": 2, "url": "https://example.com/"}]}`,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": "GitHub"}]}`,
+	)
+	client, _ := New(address, "secret")
+	var stdout, stderr bytes.Buffer
+	r := &repl{ctx: &commandContext{stdout: &stdout, stderr: &stderr}, client: client, tabs: shared.FrontTabs()}
+	reader := &fakeLineReader{lines: []string{
+		"({",
+		"  a: [1]",
+		"})",
+		".timeout 30s",
+		".target url=*github* active",
+		"document.title",
+		".bogus",
+		".exit",
+		"ignored",
+	}}
+	r.run(reader)
+
+	expected := "{\n  \"a\": [\n    1\n  ]\n}\ntimeout: 30s\n"
+	if !strings.HasPrefix(stdout.String(), expected) || !strings.HasSuffix(stdout.String(), "https://github.com/\n\"GitHub\"\n") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+	if stderr.String() != "error: unknown command .bogus; enter .help for commands\n" {
+		t.Errorf("unexpected errors: %q", stderr.String())
+	}
+	if requests[0].Query != "({\n  a: [1]\n})" || !requests[0].AwaitPromise {
+		t.Errorf("expected multi-line query that awaits promises, got %+v", requests[0])
+	}
+	yes := true
+	if !reflect.DeepEqual(*requests[1].Tabs, shared.TabSelector{Active: &yes}) || requests[1].Timeout != 30 {
+		t.Errorf("expected tabs to be listed with target and timeout, got %+v", requests[1])
+	}
+	if !reflect.DeepEqual(requests[3].Tabs.TabIds, []int{1}) || requests[3].Query != "document.title" {
+		t.Errorf("expected query in tab matching URL, got %+v", requests[3])
+	}
+	if len(reader.history) != 6 || reader.history[0] != "({\n  a: [1]\n})" {
+		t.Errorf("unexpected history: %q", reader.history)
+	}
+}
+
+func TestReplJson(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests,
+		`{"status": "ok", "results": [{"id": 1, "url": "https://github.com/"}]}`,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": "GitHub"}]}`,
+	)
+	client, _ := New(address, "secret")
+	var stdout, stderr bytes.Buffer
+	r := &repl{ctx: &commandContext{stdout: &stdout, stderr: &stderr, json: true}, client: client, tabs: shared.FrontTabs()}
+	r.run(&fakeLineReader{lines: []string{".tabs", "document.title"}})
+
+	expected := "[{\"id\":1,\"windowId\":0,\"index\":0,\"url\":\"https://github.com/\",\"title\":\"\",\"active\":false,\"pinned\":false,\"audible\":false,\"status\":\"\"}]\n" +
+		"{\"status\": \"ok\", \"results\": [{\"tabId\": 1, \"status\": \"ok\", \"value\": \"GitHub\"}]}\n"
+	if stdout.String() != expected {
+		t.Errorf("unexpected output: %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestReplHistory(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": [2, 4]}]}`,
+		`{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": [2, 4]}]}`,
+	)
+	client, _ := New(address, "secret")
+	var stdout, stderr bytes.Buffer
+	r := &repl{ctx: &commandContext{stdout: &stdout, stderr: &stderr}, client: client, tabs: shared.FrontTabs()}
+	reader := &fakeLineReader{lines: []string{"[1, // first", "2].map(x => x * 2)", "<up>"}}
+	r.run(reader)
+
+	query := "[1, // first\n2].map(x => x * 2)"
+	if len(requests) != 2 || requests[0].Query != query || requests[1].Query != query {
+		t.Errorf("expected recalled query to keep its lines, got %+v", requests)
+	}
+	if stderr.String() != "" {
+		t.Errorf("unexpected errors: %q", stderr.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "browser_remote", "repl_history")
+	entries := []string{query, "\"quoted\"", "document.title"}
+	if err := writeHistory(path, entries); err != nil {
+		t.Fatalf("unable to write history: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "\"[1, // first\\n2].map(x => x * 2)\"\n\"\\\"quoted\\\"\"\ndocument.title\n" {
+		t.Errorf("unexpected history file: %q", data)
+	}
+	if read, err := readHistory(path); err != nil || !reflect.DeepEqual(read, entries) {
+		t.Errorf("expected history to be read back, got %q, %v", read, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var requests []shared.MessageToWebServer
+	address := startServer(t, &requests, `{"status": "ok", "results": [{"tabId": 1, "status": "ok", "value": 200}]}`)
+	script := filepath.Join(t.TempDir(), "script.js")
+	os.WriteFile(script, []byte("const r = await fetch(args.url);\nreturn r.status;\n"), 0600)
+
+	exitCode, stdout, stderr := runMain("run", "--address", address, "--token", "secret", script, "--arg", "url=/api", "--arg-json", "ids=[1,2]", "--tabs", "all")
+	if exitCode != ExitOk || stdout != "200\n" {
+		t.Errorf("unexpected output: %v, %q, %q", exitCode, stdout, stderr)
+	}
+	var args shared.RunArgs
+	json.Unmarshal(requests[0].Args, &args)
+	expected := shared.RunArgs{Script: "const r = await fetch(args.url);\nreturn r.status;\n", Args: map[string]any{"url": "/api", "ids": []any{1.0, 2.0}}}
+	if requests[0].Command != shared.CommandRun || requests[0].Version != 2 || !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected request with %+v, got %+v, %s", expected, requests[0], requests[0].Args)
+	}
+
+	if exitCode, _, _ := runMain("run", script, "--arg", "url"); exitCode != ExitUsage {
+		t.Errorf("expected usage error for arg without value, got %v", exitCode)
+	}
+	if exitCode, _, _ := runMain("run", script, "--arg-json", "ids=[1"); exitCode != ExitUsage {
+		t.Errorf("expected usage error for invalid JSON arg, got %v", exitCode)
+	}
+	if exitCode, _, _ := runMain("run", filepath.Join(t.TempDir(), "missing.js")); exitCode != ExitUsage {
+		t.Errorf("expected usage error for missing script, got %v", exitCode)
+	}
+}
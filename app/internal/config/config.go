@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Settings read from a config file. Browsers launch the app without any arguments, so this is
+// the only way to configure it when running as a native messaging host.
+type Config struct {
+	// Token that clients must send in an Authorization header; a random one is generated if empty.
+	Token string `json:"token"`
+	// Host names that requests may be sent to, in addition to local ones.
+	AllowedHosts []string `json:"allowedHosts"`
+	// Origins of web pages that may send requests, like "https://example.com".
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// Path of a Unix domain socket to serve on, instead of a port.
+	Socket string `json:"socket"`
+}
+
+// Returns the path to the config file, e.g. ~/.config/browser_remote/config.json.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "browser_remote", "config.json")
+}
+
+// Reads the config file at path. A missing file results in an empty config.
+func Load(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Describes a running native app, so clients can find its web server.
+type Instance struct {
+	Address string `json:"address"`
+	Pid     int    `json:"pid"`
+	Browser string `json:"browser"`
+	Token   string `json:"token"`
+}
+
+// Returns the directory where discovery files are written, e.g. $XDG_RUNTIME_DIR/browser_remote.
+func Dir() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		var err error
+		dir, err = os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+	}
+	return filepath.Join(dir, "browser_remote")
+}
+
+// Writes a discovery file for an instance, named after its PID, and returns its path. Only the
+// current user can read it, since it includes the token.
+func Write(dir string, instance Instance) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(instance, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temporary file first, so clients never see a partial file
+	path := filepath.Join(dir, strconv.Itoa(instance.Pid)+".json")
+	file, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(file.Name(), path)
+}
+
+// Returns the instances with discovery files in dir, most recently started first. Files left behind by
+// instances that are no longer running are removed.
+func List(dir string) ([]Instance, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		instance Instance
+		modTime  time.Time
+	}
+	var founds []found
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var instance Instance
+		if json.Unmarshal(data, &instance) != nil {
+			continue
+		}
+		if !isRunning(instance.Pid) {
+			os.Remove(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		founds = append(founds, found{instance, info.ModTime()})
+	}
+	slices.SortStableFunc(founds, func(a, b found) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	instances := make([]Instance, len(founds))
+	for i, found := range founds {
+		instances[i] = found.instance
+	}
+	return instances, nil
+}
+
+// Guesses which browser launched the app from its non-flag arguments, or returns "" if it wasn't
+// launched by a browser. Chromium-based browsers pass the extension's origin, and Firefox passes
+// the path to the host manifest and the extension's ID.
+func DetectBrowser(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	var family string
+	if strings.HasPrefix(args[0], "chrome-extension://") {
+		family = "chrome"
+	} else if strings.HasSuffix(args[0], ".json") && len(args) > 1 {
+		family = "firefox"
+	} else {
+		return ""
+	}
+
+	// the browser's process name distinguishes e.g. Chromium or Brave from Chrome
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", os.Getppid()))
+	if err == nil {
+		name := strings.ToLower(string(comm))
+		for _, browser := range knownBrowsers {
+			if strings.Contains(name, browser) {
+				return browser
+			}
+		}
+	}
+	return family
+}
+
+// Names of browsers, as they appear in process names. Chromium must be checked before Chrome.
+var knownBrowsers = []string{"chromium", "chrome", "brave", "edge", "vivaldi", "opera", "firefox", "librewolf", "waterfox"}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package discovery
+
+import (
+	"os"
+)
+
+// Returns whether a process is running. On Windows, finding a process opens it, which fails if it
+// has exited; elsewhere, every process is assumed to be running.
+func isRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
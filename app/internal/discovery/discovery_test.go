@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "browser_remote")
+	instance := Instance{Address: "http://localhost:5555", Pid: 123, Browser: "firefox", Token: "secret"}
+	path, err := Write(dir, instance)
+	if err != nil {
+		t.Fatalf("unable to write discovery file: %v", err)
+	}
+	if path != filepath.Join(dir, "123.json") {
+		t.Errorf("invalid path: %v", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected file with 0600 permissions, got %v, %v", info, err)
+	}
+	data, _ := os.ReadFile(path)
+	var written Instance
+	json.Unmarshal(data, &written)
+	if written != instance {
+		t.Errorf("invalid discovery file: %s", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the discovery file, got %v", entries)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	if instances, err := List(filepath.Join(dir, "missing")); err != nil || len(instances) != 0 {
+		t.Errorf("expected no instances for missing directory, got %v, %v", instances, err)
+	}
+
+	running := Instance{Address: "http://localhost:5555", Pid: os.Getpid(), Token: "secret"}
+	Write(dir, running)
+	// PIDs are never this high, so it isn't running
+	stalePath, _ := Write(dir, Instance{Address: "http://localhost:5556", Pid: 1 << 30})
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0600)
+
+	instances, err := List(dir)
+	if err != nil || len(instances) != 1 || instances[0] != running {
+		t.Errorf("expected only the running instance, got %v, %v", instances, err)
+	}
+	if _, err := os.Stat(stalePath); err == nil {
+		t.Errorf("expected stale discovery file to be removed")
+	}
+}
+
+func TestDetectBrowser(t *testing.T) {
+	if browser := DetectBrowser([]string{}); browser != "" {
+		t.Errorf("expected no browser, got %v", browser)
+	}
+	if browser := DetectBrowser([]string{"something"}); browser != "" {
+		t.Errorf("expected no browser, got %v", browser)
+	}
+	if browser := DetectBrowser([]string{"chrome-extension://abc/"}); browser == "" {
+		t.Errorf("expected a browser")
+	}
+	if browser := DetectBrowser([]string{"/path/com.jacobweber.browser_remote.json", "browser_remote@jacobweber.com"}); browser == "" {
+		t.Errorf("expected a browser")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package discovery
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Returns whether a process is running.
+func isRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	// EPERM means it exists, but belongs to someone else
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
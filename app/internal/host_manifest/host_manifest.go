@@ -0,0 +1,174 @@
+package host_manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Name of the native messaging host, which the extension connects to.
+const Name = "com.jacobweber.browser_remote"
+
+// ID of the published Firefox extension. Chromium-based browsers load the extension unpacked, and
+// give it an ID based on where it was loaded from, so there's no default for them.
+const FirefoxExtensionId = "browser_remote@jacobweber.com"
+
+var ErrExtensionIdRequired = errors.New("extension ID is required for Chromium-based browsers")
+
+// A browser that the native app can be installed in.
+type Browser struct {
+	Name string
+	// Directory of the browser's per-user settings, relative to the home directory.
+	configDir string
+	// Directory of host manifests, relative to the home directory.
+	manifestDir string
+	// Firefox identifies extensions by ID, and Chromium-based browsers by origin.
+	firefox bool
+}
+
+// Browsers with known manifest locations on Linux.
+var Browsers = []Browser{
+	{Name: "chrome", configDir: ".config/google-chrome", manifestDir: ".config/google-chrome/NativeMessagingHosts"},
+	{Name: "chromium", configDir: ".config/chromium", manifestDir: ".config/chromium/NativeMessagingHosts"},
+	{Name: "brave", configDir: ".config/BraveSoftware/Brave-Browser", manifestDir: ".config/BraveSoftware/Brave-Browser/NativeMessagingHosts"},
+	{Name: "edge", configDir: ".config/microsoft-edge", manifestDir: ".config/microsoft-edge/NativeMessagingHosts"},
+	{Name: "firefox", configDir: ".mozilla", manifestDir: ".mozilla/native-messaging-hosts", firefox: true},
+}
+
+// Returns the browser with a name, like "chrome".
+func FindBrowser(name string) (Browser, bool) {
+	i := slices.IndexFunc(Browsers, func(browser Browser) bool { return browser.Name == name })
+	if i == -1 {
+		return Browser{}, false
+	}
+	return Browsers[i], true
+}
+
+// Returns whether the browser has been run by the user whose home directory is home.
+func (browser Browser) Detected(home string) bool {
+	_, err := os.Stat(filepath.Join(home, browser.configDir))
+	return err == nil
+}
+
+// Returns the path of the browser's host manifest for the native app.
+func (browser Browser) ManifestPath(home string) string {
+	return filepath.Join(home, browser.manifestDir, Name+".json")
+}
+
+// Returns the ID of the published extension for the browser, or "" if there isn't one.
+func (browser Browser) DefaultExtensionId() string {
+	if browser.firefox {
+		return FirefoxExtensionId
+	}
+	return ""
+}
+
+// Native messaging host manifest, which tells a browser how to run the native app.
+type Manifest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Absolute path of the native app.
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Origins of extensions that may run the app, for Chromium-based browsers.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// IDs of extensions that may run the app, for Firefox.
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+}
+
+// Returns the manifest that lets an extension in the browser run the app at path. If extensionId is
+// empty, the published extension is used, or no extensions are allowed if there isn't one.
+func (browser Browser) Manifest(path string, extensionId string) Manifest {
+	if extensionId == "" {
+		extensionId = browser.DefaultExtensionId()
+	}
+	manifest := Manifest{
+		Name:        Name,
+		Description: "Run JavaScript from other applications",
+		Path:        path,
+		Type:        "stdio",
+	}
+	if extensionId == "" {
+		return manifest
+	}
+	if browser.firefox {
+		manifest.AllowedExtensions = []string{extensionId}
+	} else {
+		manifest.AllowedOrigins = []string{"chrome-extension://" + extensionId + "/"}
+	}
+	return manifest
+}
+
+// Writes the browser's manifest for the app at path, replacing any existing one, and returns the
+// manifest's path. Returns ErrExtensionIdRequired if extensionId is empty and the browser has no
+// published extension.
+func (browser Browser) Install(home string, path string, extensionId string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %v isn't absolute", path)
+	}
+	if extensionId == "" && browser.DefaultExtensionId() == "" {
+		return "", ErrExtensionIdRequired
+	}
+	data, err := json.MarshalIndent(browser.Manifest(path, extensionId), "", "\t")
+	if err != nil {
+		return "", err
+	}
+	manifestPath := browser.ManifestPath(home)
+	err = os.MkdirAll(filepath.Dir(manifestPath), 0755)
+	if err != nil {
+		return "", err
+	}
+	return manifestPath, os.WriteFile(manifestPath, append(data, '\n'), 0644)
+}
+
+// Removes the browser's manifest, and returns its path. Returns fs.ErrNotExist if it wasn't
+// installed.
+func (browser Browser) Uninstall(home string) (string, error) {
+	manifestPath := browser.ManifestPath(home)
+	return manifestPath, os.Remove(manifestPath)
+}
+
+// Checks the browser's manifest against the one Install would write, and returns a description
+// of each problem. If extensionId is empty and the browser has no published extension, any
+// extension is accepted. Returns fs.ErrNotExist if it isn't installed.
+func (browser Browser) Check(home string, path string, extensionId string) ([]string, error) {
+	data, err := os.ReadFile(browser.ManifestPath(home))
+	if err != nil {
+		return nil, err
+	}
+	var installed Manifest
+	err = json.Unmarshal(data, &installed)
+	if err != nil {
+		return []string{fmt.Sprintf("manifest isn't valid JSON: %v", err)}, nil
+	}
+	expected := browser.Manifest(path, extensionId)
+
+	var problems []string
+	if installed.Name != expected.Name {
+		problems = append(problems, fmt.Sprintf("name is %q instead of %q", installed.Name, expected.Name))
+	}
+	if installed.Type != expected.Type {
+		problems = append(problems, fmt.Sprintf("type is %q instead of %q", installed.Type, expected.Type))
+	}
+	if info, err := os.Stat(installed.Path); errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, fmt.Sprintf("path %v doesn't exist", installed.Path))
+	} else if err != nil || info.IsDir() || info.Mode().Perm()&0100 == 0 {
+		problems = append(problems, fmt.Sprintf("path %v isn't executable", installed.Path))
+	} else if installed.Path != expected.Path {
+		problems = append(problems, fmt.Sprintf("path is %v instead of %v", installed.Path, expected.Path))
+	}
+	if browser.firefox {
+		if !slices.Contains(installed.AllowedExtensions, expected.AllowedExtensions[0]) {
+			problems = append(problems, fmt.Sprintf("allowed_extensions %q doesn't include %v", installed.AllowedExtensions, expected.AllowedExtensions[0]))
+		}
+	} else if len(installed.AllowedOrigins) == 0 {
+		problems = append(problems, "allowed_origins is empty")
+	} else if len(expected.AllowedOrigins) > 0 && !slices.Contains(installed.AllowedOrigins, expected.AllowedOrigins[0]) {
+		problems = append(problems, fmt.Sprintf("allowed_origins %q doesn't include %v", installed.AllowedOrigins, expected.AllowedOrigins[0]))
+	}
+	return problems, nil
+}
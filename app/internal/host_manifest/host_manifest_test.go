@@ -0,0 +1,113 @@
+package host_manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInstall(t *testing.T) {
+	home := t.TempDir()
+	app := filepath.Join(home, "bin", "browser_remote")
+	os.MkdirAll(filepath.Dir(app), 0755)
+	os.WriteFile(app, []byte{}, 0755)
+
+	chrome, _ := FindBrowser("chrome")
+	firefox, _ := FindBrowser("firefox")
+	if chrome.Detected(home) {
+		t.Errorf("expected chrome not to be detected")
+	}
+	if _, err := chrome.Check(home, app, ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected missing manifest, got %v", err)
+	}
+
+	if _, err := chrome.Install(home, app, ""); !errors.Is(err, ErrExtensionIdRequired) {
+		t.Errorf("expected error without extension ID, got %v", err)
+	}
+	path, err := chrome.Install(home, app, "jgmdchjaeklnmaikghgeiodkegiiedge")
+	if err != nil || path != filepath.Join(home, ".config/google-chrome/NativeMessagingHosts/com.jacobweber.browser_remote.json") {
+		t.Fatalf("unable to install: %v, %v", path, err)
+	}
+	if !chrome.Detected(home) {
+		t.Errorf("expected chrome to be detected")
+	}
+	var manifest Manifest
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &manifest)
+	expected := Manifest{
+		Name:           "com.jacobweber.browser_remote",
+		Description:    "Run JavaScript from other applications",
+		Path:           app,
+		Type:           "stdio",
+		AllowedOrigins: []string{"chrome-extension://jgmdchjaeklnmaikghgeiodkegiiedge/"},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("expected manifest %+v, got %s", expected, data)
+	}
+	if problems, err := chrome.Check(home, app, "jgmdchjaeklnmaikghgeiodkegiiedge"); err != nil || len(problems) != 0 {
+		t.Errorf("expected no problems, got %v, %v", problems, err)
+	}
+	if problems, err := chrome.Check(home, app, ""); err != nil || len(problems) != 0 {
+		t.Errorf("expected any extension to be accepted without an ID, got %v, %v", problems, err)
+	}
+	if problems, err := chrome.Check(home, app, "other"); err != nil || len(problems) != 1 {
+		t.Errorf("expected wrong extension to be reported, got %v, %v", problems, err)
+	}
+
+	path, _ = firefox.Install(home, app, "other@example.com")
+	data, _ = os.ReadFile(path)
+	manifest = Manifest{}
+	json.Unmarshal(data, &manifest)
+	if path != filepath.Join(home, ".mozilla/native-messaging-hosts/com.jacobweber.browser_remote.json") || !reflect.DeepEqual(manifest.AllowedExtensions, []string{"other@example.com"}) || manifest.AllowedOrigins != nil {
+		t.Errorf("unexpected firefox manifest at %v: %s", path, data)
+	}
+
+	if _, err := chrome.Install(home, "browser_remote", "jgmdchjaeklnmaikghgeiodkegiiedge"); err == nil {
+		t.Errorf("expected error for relative path")
+	}
+
+	if _, err := chrome.Uninstall(home); err != nil {
+		t.Errorf("unable to uninstall: %v", err)
+	}
+	if _, err := chrome.Uninstall(home); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected missing manifest, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	home := t.TempDir()
+	app := filepath.Join(home, "browser_remote")
+	os.WriteFile(app, []byte{}, 0755)
+	moved := filepath.Join(home, "moved")
+	os.WriteFile(moved, []byte{}, 0755)
+	notExecutable := filepath.Join(home, "not_executable")
+	os.WriteFile(notExecutable, []byte{}, 0644)
+	chrome, _ := FindBrowser("chrome")
+	path := chrome.ManifestPath(home)
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	tests := []struct {
+		manifest string
+		problems int
+	}{
+		{`{"name": "com.jacobweber.browser_remote", "path": "` + app + `", "type": "stdio", "allowed_origins": ["chrome-extension://jgmdchjaeklnmaikghgeiodkegiiedge/"]}`, 0},
+		{`{"name": "com.jacobweber.browser_remote", "path": "` + moved + `", "type": "stdio", "allowed_origins": ["chrome-extension://jgmdchjaeklnmaikghgeiodkegiiedge/"]}`, 1},
+		{`{"name": "com.jacobweber.browser_remote", "path": "/missing/browser_remote", "type": "stdio", "allowed_origins": ["chrome-extension://jgmdchjaeklnmaikghgeiodkegiiedge/"]}`, 1},
+		{`{"name": "com.jacobweber.browser_remote", "path": "` + notExecutable + `", "type": "stdio", "allowed_origins": ["chrome-extension://jgmdchjaeklnmaikghgeiodkegiiedge/"]}`, 1},
+		{`{"name": "com.jacobweber.browser_remote", "path": "` + app + `", "type": "stdio", "allowed_origins": ["chrome-extension://abc/"]}`, 1},
+		{`{"name": "other", "path": "` + app + `", "type": "pipe"}`, 3},
+		{`{"name": "com.jacobweber.browser_remote", "path": "` + app + `", "type": "stdio"}`, 1},
+		{`{`, 1},
+	}
+	for _, test := range tests {
+		os.WriteFile(path, []byte(test.manifest), 0644)
+		problems, err := chrome.Check(home, app, "jgmdchjaeklnmaikghgeiodkegiiedge")
+		if err != nil || len(problems) != test.problems {
+			t.Errorf("%v: expected %v problems, got %q, %v", test.manifest, test.problems, problems, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package native_messaging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Room left in each chunk's message for the fields other than its data.
+const chunkOverhead = 256
+
+// Maximum number of chunked messages that can be partially received at once.
+const maxPendingChunkedMessages = 16
+
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// Part of a message that was too large to send at once. Chunks are sent in order, as
+// separate messages of the form {"chunk": {...}}.
+type chunk struct {
+	Id    string `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+	// base64-encoded part of the message's JSON
+	Data string `json:"data"`
+}
+
+type chunkEnvelope struct {
+	Chunk *chunk `json:"chunk"`
+}
+
+// Splits an encoded message into chunk messages no larger than maxMessageSize.
+func splitMessage(msg []byte, id string, maxMessageSize int) ([][]byte, error) {
+	partSize := base64.StdEncoding.DecodedLen(maxMessageSize - chunkOverhead)
+	if partSize <= 0 {
+		return nil, fmt.Errorf("maximum message size of %d is too small for chunks", maxMessageSize)
+	}
+	count := (len(msg) + partSize - 1) / partSize
+	chunks := make([][]byte, 0, count)
+	for index := range count {
+		part := msg[index*partSize : min((index+1)*partSize, len(msg))]
+		chunkMsg, err := json.Marshal(chunkEnvelope{Chunk: &chunk{
+			Id:    id,
+			Index: index,
+			Count: count,
+			Data:  base64.StdEncoding.EncodeToString(part),
+		}})
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunkMsg)
+	}
+	return chunks, nil
+}
+
+type partialMessage struct {
+	parts [][]byte
+	size  int
+}
+
+// Reassembles messages from their chunks, which must arrive in order.
+type chunkAssembler struct {
+	pending        map[string]*partialMessage
+	maxMessageSize int
+	// remaining chunks of a message that was dropped are ignored
+	droppedId string
+}
+
+func newChunkAssembler(maxMessageSize int) *chunkAssembler {
+	return &chunkAssembler{
+		pending:        make(map[string]*partialMessage),
+		maxMessageSize: maxMessageSize,
+		droppedId:      "",
+	}
+}
+
+// Returns the chunk in msg, or nil if it's a regular message.
+func parseChunk(msg []byte) *chunk {
+	var envelope chunkEnvelope
+	err := json.Unmarshal(msg, &envelope)
+	if err != nil {
+		return nil
+	}
+	return envelope.Chunk
+}
+
+// Adds a chunk, and returns its whole message once every chunk has been received.
+func (ca *chunkAssembler) add(c chunk) ([]byte, error) {
+	partial, ok := ca.pending[c.Id]
+	if !ok {
+		if c.Id == ca.droppedId {
+			return nil, nil
+		}
+		if c.Index != 0 || c.Count <= 0 {
+			return nil, ca.drop(c.Id, fmt.Errorf("%w: %v starts with index %d of %d", ErrInvalidChunk, c.Id, c.Index, c.Count))
+		}
+		if len(ca.pending) >= maxPendingChunkedMessages {
+			return nil, ca.drop(c.Id, fmt.Errorf("%w: too many partial messages to add %v", ErrInvalidChunk, c.Id))
+		}
+		partial = &partialMessage{parts: make([][]byte, 0, c.Count)}
+		ca.pending[c.Id] = partial
+	}
+
+	if c.Count != cap(partial.parts) || c.Index != len(partial.parts) {
+		return nil, ca.drop(c.Id, fmt.Errorf("%w: %v has unexpected index %d of %d", ErrInvalidChunk, c.Id, c.Index, c.Count))
+	}
+	part, err := base64.StdEncoding.DecodeString(c.Data)
+	if err != nil {
+		return nil, ca.drop(c.Id, fmt.Errorf("%w: %v: %w", ErrInvalidChunk, c.Id, err))
+	}
+	partial.size += len(part)
+	if partial.size > ca.maxMessageSize {
+		return nil, ca.drop(c.Id, fmt.Errorf("%v exceeds maximum of %d: %w", c.Id, ca.maxMessageSize, ErrMessageTooLarge))
+	}
+	partial.parts = append(partial.parts, part)
+	if len(partial.parts) < cap(partial.parts) {
+		return nil, nil
+	}
+
+	delete(ca.pending, c.Id)
+	msg := make([]byte, 0, partial.size)
+	for _, part := range partial.parts {
+		msg = append(msg, part...)
+	}
+	return msg, nil
+}
+
+// Discards a partially received message, and ignores its remaining chunks.
+func (ca *chunkAssembler) drop(id string, err error) error {
+	delete(ca.pending, id)
+	ca.droppedId = id
+	return err
+}
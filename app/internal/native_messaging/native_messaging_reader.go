@@ -2,15 +2,26 @@ package native_messaging
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/jacobweber/browser_remote/internal/logger"
 	"github.com/jacobweber/browser_remote/internal/shared"
 )
 
+// Browsers send messages of up to 64 MiB to native apps.
+const maxFrameSize = 64 * 1024 * 1024
+
+const DefaultMaxMessageSize = maxFrameSize
+
+var ErrMessageTooLarge = errors.New("message too large")
+
+// A message length that browsers never send, so the input is corrupt or out of sync.
+var ErrInvalidLength = errors.New("invalid message length")
+
 type NativeMessagingReader[I any] struct {
 	logger *logger.Logger
 
@@ -18,12 +29,16 @@ type NativeMessagingReader[I any] struct {
 
 	inputHandle io.Reader
 
-	// size of IO buffer - adjust to accommodate message payloads
-	bufferSize int
+	// larger messages are skipped
+	maxMessageSize int
 
 	nativeEndian binary.ByteOrder
 
 	messageHandler func(I)
+
+	errorHandler func(error)
+
+	chunks *chunkAssembler
 }
 
 func NewReader[I any](logger *logger.Logger, inputHandle io.Reader, name string) *NativeMessagingReader[I] {
@@ -31,9 +46,11 @@ func NewReader[I any](logger *logger.Logger, inputHandle io.Reader, name string)
 		logger:         logger,
 		name:           name,
 		inputHandle:    inputHandle,
-		bufferSize:     8192,
+		maxMessageSize: DefaultMaxMessageSize,
 		nativeEndian:   shared.DetermineByteOrder(),
 		messageHandler: nil,
+		errorHandler:   nil,
+		chunks:         newChunkAssembler(DefaultMaxMessageSize),
 	}
 }
 
@@ -41,36 +58,61 @@ func (nm *NativeMessagingReader[I]) OnMessageRead(handler func(I)) {
 	nm.messageHandler = handler
 }
 
-// Creates a new buffered I/O reader and reads messages from inputFile.
+// Sets a handler for messages that can't be read. Reading continues after oversized or invalid
+// messages, but stops if the input is truncated, corrupt, or can't be read.
+func (nm *NativeMessagingReader[I]) OnError(handler func(error)) {
+	nm.errorHandler = handler
+}
+
+// Sets the maximum size of a message, or of a message reassembled from chunks.
+func (nm *NativeMessagingReader[I]) SetMaxMessageSize(size int) {
+	nm.maxMessageSize = size
+	nm.chunks.maxMessageSize = size
+}
+
+// Reads messages from inputFile until it's closed.
 func (nm *NativeMessagingReader[I]) Start() {
 	nm.logger.Trace.Printf("%v: reader started with native byte order: %v", nm.name, nm.nativeEndian)
 
-	v := bufio.NewReader(nm.inputHandle)
-	// adjust buffer size to accommodate your json payload size limits; default is 4096
-	s := bufio.NewReaderSize(v, nm.bufferSize)
-	nm.logger.Trace.Printf("%v: buffer reader created with buffer size of %v", nm.name, s.Size())
-
+	s := bufio.NewReader(nm.inputHandle)
 	lengthBytes := make([]byte, 4)
-	lengthNum := int(0)
 
-	// we're going to indefinitely read the first 4 bytes in buffer, which gives us the message length.
-	// if stdIn is closed we'll exit the loop and shut down host
-	for b, err := s.Read(lengthBytes); b > 0 && err == nil; b, err = s.Read(lengthBytes) {
-		// convert message length bytes to integer value
-		lengthNum = nm.readMessageLength(lengthBytes)
+	for {
+		// read the first 4 bytes of each message, which gives us the message length.
+		// if stdIn is closed we'll exit the loop and shut down host
+		_, err := io.ReadFull(s, lengthBytes)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			nm.reportError(fmt.Errorf("%v: unable to read message length: %w", nm.name, err))
+			break
+		}
+		lengthNum := nm.readMessageLength(lengthBytes)
 		nm.logger.Trace.Printf("%v: read message size in bytes: %v", nm.name, lengthNum)
 
-		// If message length exceeds size of buffer, the message will be truncated.
-		// This will likely cause an error when we attempt to unmarshal message to JSON.
-		if lengthNum > nm.bufferSize {
-			nm.logger.Error.Printf("%v: message size of %d exceeds buffer size of %d; message will be truncated and is unlikely to unmarshal to JSON", nm.name, lengthNum, nm.bufferSize)
+		if lengthNum > maxFrameSize {
+			// skipping it could swallow every message after it
+			nm.reportError(fmt.Errorf("%v: message size of %d exceeds protocol limit of %d: %w", nm.name, lengthNum, maxFrameSize, ErrInvalidLength))
+			break
+		}
+		if lengthNum > nm.maxMessageSize {
+			nm.reportError(fmt.Errorf("%v: message size of %d exceeds maximum of %d: %w", nm.name, lengthNum, nm.maxMessageSize, ErrMessageTooLarge))
+			// skip the message, so we're positioned at the next one
+			_, err := io.CopyN(io.Discard, s, int64(lengthNum))
+			if err != nil {
+				nm.reportError(fmt.Errorf("%v: unable to skip message: %w", nm.name, noEOF(err)))
+				break
+			}
+			continue
 		}
 
-		// read the content of the message from buffer
+		// read the content of the message
 		content := make([]byte, lengthNum)
-		_, err := s.Read(content)
-		if err != nil && err != io.EOF {
-			nm.logger.Error.Fatalf("%v: %v", nm.name, err)
+		_, err = io.ReadFull(s, content)
+		if err != nil {
+			nm.reportError(fmt.Errorf("%v: unable to read message: %w", nm.name, noEOF(err)))
+			break
 		}
 
 		// message has been read, now parse and process
@@ -82,30 +124,56 @@ func (nm *NativeMessagingReader[I]) Start() {
 
 // Reads and returns the message length value in native byte order.
 func (nm *NativeMessagingReader[I]) readMessageLength(msg []byte) int {
-	var length uint32
-	buf := bytes.NewBuffer(msg)
-	err := binary.Read(buf, nm.nativeEndian, &length)
-	if err != nil {
-		nm.logger.Error.Printf("%v: unable to read bytes representing message length: %v", nm.name, err)
-	}
-	return int(length)
+	return int(nm.nativeEndian.Uint32(msg))
 }
 
-// Parses incoming message from input.
+// Parses incoming message from input, once all its chunks have been received.
 func (nm *NativeMessagingReader[I]) handleMessage(msg []byte) {
-	incomingMsg := nm.decodeMessage(msg)
+	if chunk := parseChunk(msg); chunk != nil {
+		nm.logger.Trace.Printf("%v: chunk received: %v %d/%d", nm.name, chunk.Id, chunk.Index+1, chunk.Count)
+		assembled, err := nm.chunks.add(*chunk)
+		if err != nil {
+			nm.reportError(fmt.Errorf("%v: %w", nm.name, err))
+			return
+		}
+		if assembled == nil {
+			return
+		}
+		msg = assembled
+	}
+
 	nm.logger.Trace.Printf("%v: message received: %s", nm.name, msg)
+	incomingMsg, err := nm.decodeMessage(msg)
+	if err != nil {
+		nm.reportError(err)
+		return
+	}
 	if nm.messageHandler != nil {
 		nm.messageHandler(incomingMsg)
 	}
 }
 
 // Unmarshals incoming json request and returns query value.
-func (nm *NativeMessagingReader[I]) decodeMessage(msg []byte) I {
+func (nm *NativeMessagingReader[I]) decodeMessage(msg []byte) (I, error) {
 	var incomingMsg I
 	err := json.Unmarshal(msg, &incomingMsg)
 	if err != nil {
-		nm.logger.Error.Printf("%v: unable to unmarshal json to struct: %v", nm.name, err)
+		return incomingMsg, fmt.Errorf("%v: unable to unmarshal json to struct: %w", nm.name, err)
+	}
+	return incomingMsg, nil
+}
+
+func (nm *NativeMessagingReader[I]) reportError(err error) {
+	nm.logger.Error.Print(err)
+	if nm.errorHandler != nil {
+		nm.errorHandler(err)
+	}
+}
+
+// Input that ends partway through a message was truncated.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	return incomingMsg
+	return err
 }
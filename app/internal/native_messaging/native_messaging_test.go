@@ -1,10 +1,16 @@
 package native_messaging
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/jacobweber/browser_remote/internal/logger"
+	"github.com/jacobweber/browser_remote/internal/shared"
 )
 
 type TestMessageFromBrowser struct {
@@ -96,3 +102,225 @@ func TestNativeMessaging(t *testing.T) {
 	messageWriterToBrowser.Done()
 	messageWriterToNative.Done()
 }
+
+func TestNativeMessagingLargeMessage(t *testing.T) {
+	logger := logger.NewStdout()
+
+	readerFromBrowser, writerToNative := io.Pipe()
+	messageReaderFromBrowser := NewReader[TestMessageFromBrowser](logger, readerFromBrowser, "from browser")
+	messageWriterToNative := NewWriter[TestMessageFromBrowser](logger, writerToNative, "to native")
+
+	messageFromBrowserHandler := NewTestMessageFromBrowserHandler()
+	messageReaderFromBrowser.OnMessageRead(func(msg TestMessageFromBrowser) {
+		messageFromBrowserHandler.HandleMessage(msg)
+	})
+
+	readerFromBrowserDone := make(chan bool)
+	go func() {
+		messageReaderFromBrowser.Start()
+		readerFromBrowserDone <- true
+	}()
+	go messageWriterToNative.Start()
+
+	answer := strings.Repeat("x", 100000)
+	messageWriterToNative.SendMessage(TestMessageFromBrowser{Answer: answer})
+	messageFromBrowser := <-messageFromBrowserHandler.messages
+	if messageFromBrowser.Answer != answer {
+		t.Errorf("Invalid message received from browser with length: %v", len(messageFromBrowser.Answer))
+	}
+
+	writerToNative.Close()
+	<-readerFromBrowserDone
+	messageWriterToNative.Done()
+}
+
+// Encodes messages in native messaging format.
+func encodeFrames(messages ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		binary.Write(&buf, shared.DetermineByteOrder(), uint32(len(msg)))
+		buf.WriteString(msg)
+	}
+	return &buf
+}
+
+// Reads all messages from input, and returns the messages and errors.
+func readFrames(input io.Reader, maxMessageSize int) ([]TestMessageFromBrowser, []error) {
+	messageReader := NewReader[TestMessageFromBrowser](logger.NewStdout(), input, "from browser")
+	if maxMessageSize > 0 {
+		messageReader.SetMaxMessageSize(maxMessageSize)
+	}
+	var messages []TestMessageFromBrowser
+	var errs []error
+	messageReader.OnMessageRead(func(msg TestMessageFromBrowser) {
+		messages = append(messages, msg)
+	})
+	messageReader.OnError(func(err error) {
+		errs = append(errs, err)
+	})
+	messageReader.Start()
+	return messages, errs
+}
+
+func TestNativeMessagingReader(t *testing.T) {
+	t.Run("reads messages split across short reads", func(t *testing.T) {
+		input := iotest.OneByteReader(encodeFrames("{\"answer\":\"john\"}", "{\"answer\":\"jim\"}"))
+		messages, errs := readFrames(input, 0)
+		if len(messages) != 2 || messages[0].Answer != "john" || messages[1].Answer != "jim" {
+			t.Errorf("Invalid messages received: %v", messages)
+		}
+		if len(errs) != 0 {
+			t.Errorf("Unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("skips messages that are too large", func(t *testing.T) {
+		input := encodeFrames("{\"answer\":\"a long answer\"}", "{\"answer\":\"jim\"}")
+		messages, errs := readFrames(input, 20)
+		if len(messages) != 1 || messages[0].Answer != "jim" {
+			t.Errorf("Invalid messages received: %v", messages)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrMessageTooLarge) {
+			t.Errorf("Expected message too large error, got: %v", errs)
+		}
+	})
+
+	t.Run("stops on invalid message lengths", func(t *testing.T) {
+		var input bytes.Buffer
+		binary.Write(&input, shared.DetermineByteOrder(), uint32(0xfffffff0))
+		input.Write(encodeFrames("{\"answer\":\"jim\"}").Bytes())
+		messages, errs := readFrames(&input, 20)
+		if len(messages) != 0 {
+			t.Errorf("Invalid messages received: %v", messages)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrInvalidLength) {
+			t.Errorf("Expected invalid length error, got: %v", errs)
+		}
+	})
+
+	t.Run("skips invalid JSON", func(t *testing.T) {
+		input := encodeFrames("{\"answer\":", "{\"answer\":\"jim\"}")
+		messages, errs := readFrames(input, 0)
+		if len(messages) != 1 || messages[0].Answer != "jim" {
+			t.Errorf("Invalid messages received: %v", messages)
+		}
+		if len(errs) != 1 {
+			t.Errorf("Expected JSON error, got: %v", errs)
+		}
+	})
+
+	t.Run("stops on truncated messages", func(t *testing.T) {
+		input := encodeFrames("{\"answer\":\"john\"}", "{\"answer\":\"jim\"}")
+		input.Truncate(input.Len() - 2)
+		messages, errs := readFrames(input, 0)
+		if len(messages) != 1 || messages[0].Answer != "john" {
+			t.Errorf("Invalid messages received: %v", messages)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+			t.Errorf("Expected unexpected EOF error, got: %v", errs)
+		}
+	})
+}
+
+func TestNativeMessagingChunks(t *testing.T) {
+	logger := logger.NewStdout()
+
+	readerFromBrowser, writerToNative := io.Pipe()
+	readerFromNative, writerToBrowser := io.Pipe()
+
+	messageReaderFromBrowser := NewReader[TestMessageFromBrowser](logger, readerFromBrowser, "from browser")
+	messageWriterToBrowser := NewWriter[TestMessageToBrowser](logger, writerToBrowser, "to browser")
+	messageReaderFromNative := NewReader[TestMessageToBrowser](logger, readerFromNative, "from native")
+	messageWriterToNative := NewWriter[TestMessageFromBrowser](logger, writerToNative, "to native")
+	messageWriterToBrowser.SetMaxMessageSize(400)
+	messageWriterToNative.SetMaxMessageSize(400)
+	messageReaderFromBrowser.SetMaxMessageSize(2000)
+	messageReaderFromNative.SetMaxMessageSize(2000)
+
+	messageFromBrowserHandler := NewTestMessageFromBrowserHandler()
+	messageFromNativeHandler := NewTestMessageFromNativeHandler()
+	messageReaderFromBrowser.OnMessageRead(func(msg TestMessageFromBrowser) {
+		messageFromBrowserHandler.HandleMessage(msg)
+	})
+	messageReaderFromNative.OnMessageRead(func(msg TestMessageToBrowser) {
+		messageFromNativeHandler.HandleMessage(msg)
+	})
+	errs := make(chan error, 1)
+	messageReaderFromBrowser.OnError(func(err error) {
+		errs <- err
+	})
+
+	readerFromBrowserDone := make(chan bool)
+	readerFromNativeDone := make(chan bool)
+	go func() {
+		messageReaderFromBrowser.Start()
+		readerFromBrowserDone <- true
+	}()
+	go func() {
+		messageReaderFromNative.Start()
+		readerFromNativeDone <- true
+	}()
+	go messageWriterToBrowser.Start()
+	go messageWriterToNative.Start()
+
+	// multi-byte characters may be split between chunks
+	question := strings.Repeat("whät \U0001F600 ", 100)
+	messageWriterToBrowser.SendMessage(TestMessageToBrowser{Question: question})
+	messageFromNative := <-messageFromNativeHandler.messages
+	if messageFromNative.Question != question {
+		t.Errorf("Invalid message received from native: %v", messageFromNative.Question)
+	}
+
+	answer := strings.Repeat("john ", 300)
+	messageWriterToNative.SendMessage(TestMessageFromBrowser{Answer: answer})
+	messageFromBrowser := <-messageFromBrowserHandler.messages
+	if messageFromBrowser.Answer != answer {
+		t.Errorf("Invalid message received from browser: %v", messageFromBrowser.Answer)
+	}
+
+	// reassembled messages must not exceed the maximum size either
+	messageWriterToNative.SendMessage(TestMessageFromBrowser{Answer: strings.Repeat("john ", 500)})
+	if err := <-errs; !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected message too large error, got: %v", err)
+	}
+	messageWriterToNative.SendMessage(TestMessageFromBrowser{Answer: "jim"})
+	messageFromBrowser = <-messageFromBrowserHandler.messages
+	if messageFromBrowser.Answer != "jim" {
+		t.Errorf("Invalid message received from browser: %v", messageFromBrowser.Answer)
+	}
+
+	writerToNative.Close()
+	writerToBrowser.Close()
+	<-readerFromBrowserDone
+	<-readerFromNativeDone
+	messageWriterToBrowser.Done()
+	messageWriterToNative.Done()
+}
+
+func TestChunkAssembler(t *testing.T) {
+	chunks := newChunkAssembler(100)
+	if _, err := chunks.add(chunk{Id: "1", Index: 1, Count: 2, Data: ""}); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("Expected invalid chunk error for first index, got: %v", err)
+	}
+	if _, err := chunks.add(chunk{Id: "2", Index: 0, Count: 2, Data: "!"}); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("Expected invalid chunk error for data, got: %v", err)
+	}
+	// remaining chunks of a dropped message are ignored
+	if _, err := chunks.add(chunk{Id: "2", Index: 1, Count: 2, Data: "Yg=="}); err != nil {
+		t.Errorf("Expected chunk to be ignored, got: %v", err)
+	}
+
+	chunks.add(chunk{Id: "3", Index: 0, Count: 3, Data: "Yg=="})
+	if _, err := chunks.add(chunk{Id: "3", Index: 2, Count: 3, Data: "Yg=="}); !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("Expected invalid chunk error for skipped index, got: %v", err)
+	}
+
+	chunks.add(chunk{Id: "4", Index: 0, Count: 2, Data: "YQ=="})
+	msg, err := chunks.add(chunk{Id: "4", Index: 1, Count: 2, Data: "Yg=="})
+	if err != nil || string(msg) != "ab" {
+		t.Errorf("Invalid message reassembled: %s, %v", msg, err)
+	}
+	if len(chunks.pending) != 0 {
+		t.Errorf("Expected no pending messages, got: %v", chunks.pending)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"io"
+	"strconv"
 
 	"github.com/jacobweber/browser_remote/internal/logger"
 	"github.com/jacobweber/browser_remote/internal/shared"
 )
 
+// Browsers accept messages of up to 1 MB from native apps.
+const DefaultMaxOutgoingMessageSize = 1024 * 1024
+
 type NativeMessagingWriter[O any] struct {
 	logger *logger.Logger
 
@@ -19,16 +23,24 @@ type NativeMessagingWriter[O any] struct {
 
 	sends chan O
 
+	// larger messages are split into chunks
+	maxMessageSize int
+
+	// used to generate IDs for chunked messages
+	chunkCount int
+
 	nativeEndian binary.ByteOrder
 }
 
 func NewWriter[O any](logger *logger.Logger, outputHandle io.Writer, name string) *NativeMessagingWriter[O] {
 	return &NativeMessagingWriter[O]{
-		logger:       logger,
-		name:         name,
-		outputHandle: outputHandle,
-		sends:        make(chan O),
-		nativeEndian: shared.DetermineByteOrder(),
+		logger:         logger,
+		name:           name,
+		outputHandle:   outputHandle,
+		sends:          make(chan O),
+		maxMessageSize: DefaultMaxOutgoingMessageSize,
+		chunkCount:     0,
+		nativeEndian:   shared.DetermineByteOrder(),
 	}
 }
 
@@ -42,14 +54,37 @@ func (nm *NativeMessagingWriter[O]) Done() {
 	close(nm.sends)
 }
 
+func (nm *NativeMessagingWriter[O]) SetMaxMessageSize(size int) {
+	nm.maxMessageSize = size
+}
+
 // Queues an outgoing message to be sent to outputFile.
 func (nm *NativeMessagingWriter[O]) SendMessage(msg O) {
 	nm.sends <- msg
 }
 
-// Sends an outgoing message to outputFile.
+// Sends an outgoing message to outputFile, split into chunks if it's too large.
 func (nm *NativeMessagingWriter[O]) sendMessageNow(msg O) {
 	byteMsg := nm.dataToBytes(msg)
+	if len(byteMsg) <= nm.maxMessageSize {
+		nm.writeMessage(byteMsg)
+		return
+	}
+
+	nm.chunkCount++
+	chunks, err := splitMessage(byteMsg, strconv.Itoa(nm.chunkCount), nm.maxMessageSize)
+	if err != nil {
+		nm.logger.Error.Printf("%v: unable to split message into chunks: %v", nm.name, err)
+		return
+	}
+	nm.logger.Trace.Printf("%v: splitting message of %d bytes into %d chunks", nm.name, len(byteMsg), len(chunks))
+	for _, chunk := range chunks {
+		nm.writeMessage(chunk)
+	}
+}
+
+// Writes an encoded message to outputFile.
+func (nm *NativeMessagingWriter[O]) writeMessage(byteMsg []byte) {
 	nm.writeMessageLength(byteMsg)
 
 	var msgBuf bytes.Buffer
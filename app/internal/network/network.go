@@ -1,33 +1,48 @@
 package network
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/jacobweber/browser_remote/internal/logger"
 )
 
-func FindFreePort(logger *logger.Logger, host string, port int, maxTries int, increment bool) (int, bool) {
-	timeout := time.Second / 2
+const (
+	// Port the web server listens on, unless configured otherwise.
+	DefaultPort = 5555
+	// How many ports, starting at the configured one, are tried before using any free port.
+	DefaultMaxTries = 10
+)
+
+// Listens on a TCP port, trying the following ports if it's in use, and then any free port.
+func Listen(logger *logger.Logger, host string, port int, maxTries int) (net.Listener, error) {
 	for i := 0; i < maxTries; i++ {
-		if !isPortInUse(host, port, timeout) {
-			return port, true
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port+i)))
+		if err == nil {
+			return listener, nil
 		}
-		logger.Error.Printf("Unable to open port: %v:%v; trying another port", host, port)
-		time.Sleep(timeout)
-		if increment {
-			port++
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
 		}
+		logger.Error.Printf("Port %v:%v is in use; trying another port", host, port+i)
 	}
-	return port, false
+	logger.Error.Printf("Ports %v:%v-%v are in use; using any free port", host, port, port+maxTries-1)
+	return net.Listen("tcp", net.JoinHostPort(host, "0"))
 }
 
-func isPortInUse(host string, port int, timeout time.Duration) bool {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", host, port), timeout)
-	if err != nil || conn == nil {
-		return false
+// Returns the first of maxTries ports, starting at port, that something is listening on. This finds
+// a server started by Listen, if it didn't have to use any free port.
+func FindListening(host string, port int, maxTries int) (int, error) {
+	for i := 0; i < maxTries; i++ {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port+i)), time.Second/2)
+		if err == nil {
+			conn.Close()
+			return port + i, nil
+		}
 	}
-	conn.Close()
-	return true
+	return 0, fmt.Errorf("nothing is listening on %v:%v-%v", host, port, port+maxTries-1)
 }
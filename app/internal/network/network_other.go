@@ -0,0 +1,15 @@
+//go:build !unix
+
+package network
+
+import (
+	"errors"
+	"net"
+
+	"github.com/jacobweber/browser_remote/internal/logger"
+)
+
+// Unix domain sockets can't be restricted to the current user on this platform.
+func ListenUnix(logger *logger.Logger, path string) (net.Listener, error) {
+	return nil, errors.New("Unix domain sockets aren't supported on this platform")
+}
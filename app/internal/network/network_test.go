@@ -0,0 +1,56 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jacobweber/browser_remote/internal/logger"
+)
+
+func TestListen(t *testing.T) {
+	logger := logger.NewStdout()
+
+	first, err := Listen(logger, "127.0.0.1", 0, 1)
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer first.Close()
+	port := first.Addr().(*net.TCPAddr).Port
+
+	// tries the next port
+	second, err := Listen(logger, "127.0.0.1", port, 2)
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer second.Close()
+	if secondPort := second.Addr().(*net.TCPAddr).Port; secondPort == port {
+		t.Errorf("expected a different port than %v", port)
+	}
+
+	// falls back to any free port
+	third, err := Listen(logger, "127.0.0.1", port, 1)
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer third.Close()
+	if thirdPort := third.Addr().(*net.TCPAddr).Port; thirdPort == port {
+		t.Errorf("expected a different port than %v", port)
+	}
+}
+
+func TestFindListening(t *testing.T) {
+	logger := logger.NewStdout()
+	listener, err := Listen(logger, "127.0.0.1", 0, 1)
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	if found, err := FindListening("127.0.0.1", port, 1); err != nil || found != port {
+		t.Errorf("expected to find port %v, got %v, %v", port, found, err)
+	}
+	listener.Close()
+	if _, err := FindListening("127.0.0.1", port, 1); err == nil {
+		t.Errorf("expected error after closing port")
+	}
+}
@@ -0,0 +1,73 @@
+//go:build unix
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/logger"
+)
+
+// Listens on a Unix domain socket that only the current user can access. If the socket file
+// already exists but nothing is listening on it, it's replaced.
+func ListenUnix(logger *logger.Logger, path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		conn, err := net.DialTimeout("unix", path, time.Second/2)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%v is already in use", path)
+		}
+		logger.Trace.Printf("Removing stale socket %v", path)
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// create the socket in a directory only we can access, and move it out once others can't use it
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	tempPath := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tempPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	err = os.Chmod(tempPath, 0600)
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		listener.Close()
+		os.Remove(tempPath)
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// Removes the socket file when closed, since it was moved after being created.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
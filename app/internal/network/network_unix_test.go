@@ -0,0 +1,62 @@
+//go:build unix
+
+package network
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobweber/browser_remote/internal/logger"
+)
+
+func TestListenUnix(t *testing.T) {
+	logger := logger.NewStdout()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "browser_remote.sock")
+
+	listener, err := ListenUnix(logger, path)
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected socket with 0600 permissions, got %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the socket to be left, got %v", entries)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Errorf("unable to connect: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	if _, err := ListenUnix(logger, path); err == nil {
+		t.Errorf("expected error for socket in use")
+	}
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed on close: %v", err)
+	}
+
+	// leave a stale socket file behind
+	stale, _ := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected stale socket: %v", err)
+	}
+	listener, err = ListenUnix(logger, path)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %v", err)
+	}
+	listener.Close()
+
+	os.WriteFile(path, []byte{}, 0600)
+	if _, err := ListenUnix(logger, path); err == nil {
+		t.Errorf("expected error for regular file")
+	}
+}
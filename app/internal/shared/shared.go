@@ -1,7 +1,10 @@
 package shared
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"time"
 	"unsafe"
 )
@@ -11,30 +14,261 @@ type MessageFromBrowser struct {
 	Id      string `json:"id"`
 	Status  string `json:"status"`
 	Results []any  `json:"results"`
+	// Results for each tab, set instead of Results by newer versions of the extension.
+	TabResults []TabResult `json:"tabResults,omitempty"`
+	// Set instead of the fields above while the browser is still working on a request, such as
+	// "committed" when a navigation has replaced the page. The request keeps waiting for its response.
+	Progress string `json:"progress,omitempty"`
+	// Set instead of the fields above for events that weren't requested.
+	Event *BrowserEvent `json:"event,omitempty"`
+}
+
+// Browser lifecycle event, pushed by the browser without a request.
+type BrowserEvent struct {
+	// "tab.created", "tab.updated", "tab.removed", "window.focused", or "navigation.completed"
+	Type     string `json:"type"`
+	TabId    int    `json:"tabId,omitempty"`
+	WindowId int    `json:"windowId,omitempty"`
+	Url      string `json:"url,omitempty"`
+	Title    string `json:"title,omitempty"`
+	// Properties that changed, for "tab.updated"
+	Changes map[string]any `json:"changes,omitempty"`
 }
 
 // Message from the native host to the browser.
 type MessageToBrowser struct {
-	Id     string `json:"id"`
-	Query  string `json:"query"`
-	Tabs   string `json:"tabs"`
-	Result any    `json:"result"`
+	Id      string          `json:"id"`
+	Command string          `json:"command,omitempty"`
+	Query   string          `json:"query"`
+	Args    json.RawMessage `json:"args,omitempty"`
+	Tabs    TabSelector     `json:"tabs"`
+	// Frames within each tab; defaults to the top frame.
+	Frame  *FrameSelector `json:"frame,omitempty"`
+	Result any            `json:"result"`
+	// Whether to wait for the query's value to resolve, if it's a promise.
+	AwaitPromise bool `json:"awaitPromise,omitempty"`
+	// Milliseconds to wait for a promise before reporting a timeout in the tab, for AwaitPromise.
+	AwaitTimeout float64 `json:"awaitTimeout,omitempty"`
+	// Tells the browser to stop working on the query with this ID.
+	Cancel bool `json:"cancel,omitempty"`
 }
 
 // Request to the web server.
 type MessageToWebServer struct {
+	// One of the Command constants; defaults to CommandEval.
+	Command string `json:"command"`
+	// Expression to evaluate, for CommandEval.
 	Query string `json:"query"`
-	Tabs  string `json:"tabs"`
+	// Arguments for other commands.
+	Args json.RawMessage `json:"args"`
+	// Defaults to the front tab, or all tabs for CommandTabsList.
+	Tabs *TabSelector `json:"tabs"`
+	// Frames within each tab, for commands that run in them. Defaults to the top frame.
+	Frame *FrameSelector `json:"frame"`
+	// Seconds to wait for the browser, up to the server's maximum.
+	Timeout float64 `json:"timeout"`
+	// Version of the response format: 1 (default) for MessageFromWebServer with a value for each
+	// tab, or 2 for MessageFromWebServerV2 with a TabResult for each tab.
+	Version int `json:"version"`
+	// If set, runs Query repeatedly until it returns a truthy value in every tab, or the timeout expires.
+	WaitFor *WaitFor `json:"waitFor"`
+	// If set, waits for Query's value to resolve if it's a promise. A promise that doesn't resolve
+	// before the timeout is reported as an error in its tab.
+	AwaitPromise bool `json:"awaitPromise"`
+}
+
+// Options for requests that wait for a query to return a truthy value.
+type WaitFor struct {
+	// Seconds between attempts; defaults to 0.1.
+	Interval float64 `json:"interval"`
+}
+
+// How long a WaitFor request took.
+type WaitForResult struct {
+	// Number of times the query ran.
+	Attempts int `json:"attempts"`
+	// Seconds since the first attempt.
+	Elapsed float64 `json:"elapsed"`
 }
 
-// Response from the web server.
+// Response from the web server. If a query fails in any tab, Status is its error, and there
+// are no results.
 type MessageFromWebServer struct {
 	Status  string `json:"status"`
 	Results []any  `json:"results"`
+	// Set for WaitFor requests.
+	WaitFor *WaitForResult `json:"waitFor,omitempty"`
+}
+
+// Response from the web server for version 2 requests. Status is "ok" unless the whole request
+// failed, even if some tabs failed.
+type MessageFromWebServerV2 struct {
+	Status  string      `json:"status"`
+	Results []TabResult `json:"results"`
+	// Set for WaitFor requests.
+	WaitFor *WaitForResult `json:"waitFor,omitempty"`
+}
+
+// Result of a query in one tab.
+type TabResult struct {
+	TabId    int    `json:"tabId"`
+	WindowId int    `json:"windowId"`
+	Url      string `json:"url"`
+	Title    string `json:"title"`
+	// "ok" or "error"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Value  any    `json:"value"`
+	// Milliseconds the query took in the tab.
+	Duration float64 `json:"duration"`
+	// Whether Value was resolved from a promise, for AwaitPromise.
+	Awaited bool `json:"awaited,omitempty"`
+	// Results for each frame, set instead of Value if the request has a FrameSelector.
+	Frames []FrameResult `json:"frames,omitempty"`
+}
+
+// Result of a query in one frame of a tab.
+type FrameResult struct {
+	// 0 for the top frame.
+	FrameId int    `json:"frameId"`
+	Url     string `json:"url"`
+	// "ok" or "error"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Value  any    `json:"value"`
+	// Milliseconds the query took in the frame.
+	Duration float64 `json:"duration"`
+	// Whether Value was resolved from a promise, for AwaitPromise.
+	Awaited bool `json:"awaited,omitempty"`
+}
+
+// Selects the frames within each tab that a request is sent to. Without one, requests are sent to
+// the top frame.
+type FrameSelector struct {
+	// Every frame.
+	All      bool  `json:"all,omitempty"`
+	FrameIds []int `json:"frameIds,omitempty"`
+	// Match pattern for the frame's URL, like "*://*.example.com/*".
+	Url string `json:"url,omitempty"`
+}
+
+// Accepts "all", a frame ID, a list of frame IDs, or a URL match pattern, as well as an object.
+func (s *FrameSelector) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		if name == "all" {
+			*s = FrameSelector{All: true}
+		} else {
+			*s = FrameSelector{Url: name}
+		}
+		return nil
+	}
+	var id int
+	if json.Unmarshal(data, &id) == nil {
+		*s = FrameSelector{FrameIds: []int{id}}
+		return nil
+	}
+	var ids []int
+	if json.Unmarshal(data, &ids) == nil {
+		*s = FrameSelector{FrameIds: ids}
+		return nil
+	}
+
+	// avoid recursing into this method, but still reject unknown fields
+	type frameSelector FrameSelector
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*frameSelector)(s))
+}
+
+// Selects the tabs that a request is sent to. Tabs must match every field that's set.
+type TabSelector struct {
+	TabIds    []int `json:"tabIds,omitempty"`
+	WindowIds []int `json:"windowIds,omitempty"`
+	// Match pattern for the URL, like "*://*.example.com/*"; defaults to HTTP and HTTPS URLs.
+	Url string `json:"url,omitempty"`
+	// Regular expression for the title.
+	Title string `json:"title,omitempty"`
+	// Position of the tab within its window.
+	Index         *int  `json:"index,omitempty"`
+	Active        *bool `json:"active,omitempty"`
+	CurrentWindow *bool `json:"currentWindow,omitempty"`
+	Pinned        *bool `json:"pinned,omitempty"`
+	Audible       *bool `json:"audible,omitempty"`
+	// Maximum number of tabs, or 0 for no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Selects the active tab in the current window.
+func FrontTabs() TabSelector {
+	yes := true
+	return TabSelector{Active: &yes, CurrentWindow: &yes}
+}
+
+// Selects every tab.
+func AllTabs() TabSelector {
+	return TabSelector{}
+}
+
+// Accepts "front" or "all" as well as an object.
+func (s *TabSelector) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		switch name {
+		case "front":
+			*s = FrontTabs()
+		case "all":
+			*s = AllTabs()
+		default:
+			return fmt.Errorf("invalid tabs %q", name)
+		}
+		return nil
+	}
+
+	// avoid recursing into this method, but still reject unknown fields
+	type tabSelector TabSelector
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*tabSelector)(s))
+}
+
+// Request to the web server over a WebSocket, tagged with a client-chosen ID.
+type MessageToWebSocket struct {
+	Id string `json:"id"`
+	MessageToWebServer
+}
+
+// Response from the web server over a WebSocket, tagged with the ID of its request.
+type MessageFromWebSocket struct {
+	Id string `json:"id"`
+	MessageFromWebServer
+}
+
+// Response from the web server over a WebSocket for version 2 requests.
+type MessageFromWebSocketV2 struct {
+	Id string `json:"id"`
+	MessageFromWebServerV2
+}
+
+// State of an asynchronous job on the web server.
+type JobFromWebServer struct {
+	Id      string `json:"id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Results []any  `json:"results"`
+}
+
+// State of an asynchronous job on the web server, for version 2 requests.
+type JobFromWebServerV2 struct {
+	Id      string      `json:"id"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Results []TabResult `json:"results"`
 }
 
 type Timer interface {
 	StartTimer(time.Duration) <-chan time.Time
+	Now() time.Time
 }
 
 type RealTimer struct {
@@ -44,6 +278,10 @@ func (timer *RealTimer) StartTimer(dur time.Duration) <-chan time.Time {
 	return time.After(dur)
 }
 
+func (timer *RealTimer) Now() time.Time {
+	return time.Now()
+}
+
 func DetermineByteOrder() binary.ByteOrder {
 	// determine native byte order so that we can read message size correctly
 	var one int16 = 1
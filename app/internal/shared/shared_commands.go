@@ -0,0 +1,302 @@
+package shared
+
+// Commands that a request can run in the browser.
+const (
+	// Evaluates Query in each tab.
+	CommandEval = "eval"
+	// Lists tabs, with no arguments.
+	CommandTabsList = "tabs.list"
+	// Opens a tab, with TabsCreateArgs. Doesn't use Tabs.
+	CommandTabsCreate = "tabs.create"
+	// Closes tabs, with no arguments.
+	CommandTabsClose = "tabs.close"
+	// Activates the first tab and focuses its window, with no arguments.
+	CommandTabsActivate = "tabs.activate"
+	// Reloads tabs, with TabsReloadArgs.
+	CommandTabsReload = "tabs.reload"
+	// Moves tabs, with TabsMoveArgs.
+	CommandTabsMove = "tabs.move"
+	// Loads a URL in the first tab, with NavigateArgs, and returns a NavigateResult.
+	CommandNavigate = "navigate"
+	// Captures the visible part of the first tab, after activating it, with ScreenshotArgs.
+	// Returns a ScreenshotResult.
+	CommandScreenshot = "screenshot"
+	// Lists cookies that would be sent to a URL, with CookiesArgs. Doesn't use Tabs.
+	CommandCookiesGet = "cookies.get"
+	// Sets cookies for a URL, with CookiesSetArgs, and returns them. Doesn't use Tabs.
+	CommandCookiesSet = "cookies.set"
+	// Deletes cookies that would be sent to a URL, with CookiesArgs, and returns them. Doesn't use Tabs.
+	CommandCookiesRemove = "cookies.remove"
+	// Returns a StorageSnapshot of the first tab, with StorageArgs.
+	CommandStorageGet = "storage.get"
+	// Replaces the storage of the first tab with a StorageSnapshot, for each area it includes.
+	CommandStorageSet = "storage.set"
+	// Clears the storage of the first tab, with StorageArgs.
+	CommandStorageClear = "storage.clear"
+	// Finds elements in each tab, with DomQueryArgs. Returns a list of Elements for each tab.
+	CommandDomQuery = "dom.query"
+	// Clicks an element in each tab, with InputClickArgs. Input commands return an InputResult for each tab.
+	CommandInputClick = "input.click"
+	// Types text into an element in each tab, with InputTypeArgs.
+	CommandInputType = "input.type"
+	// Chooses options of a select element in each tab, with InputSelectArgs.
+	CommandInputSelect = "input.select"
+	// Presses a key chord in each tab, with InputPressArgs.
+	CommandInputPress = "input.press"
+	// Scrolls an element into view, or scrolls the page, in each tab, with InputScrollArgs.
+	CommandInputScroll = "input.scroll"
+	// Runs a script in each tab, with RunArgs, and returns the value it resolves to.
+	CommandRun = "run"
+)
+
+// Storage areas of a tab.
+const (
+	StorageLocal   = "local"
+	StorageSession = "session"
+)
+
+// Image formats for CommandScreenshot.
+const (
+	ImagePng  = "png"
+	ImageJpeg = "jpeg"
+)
+
+// When a navigation is finished.
+const (
+	// The page's HTML has been parsed.
+	WaitDomContentLoaded = "domcontentloaded"
+	// The page and its resources have loaded. This is the default.
+	WaitLoad = "load"
+	// The page has loaded, and made no network requests for a moment.
+	WaitNetworkIdle = "networkidle"
+	// An element matching a CSS selector exists.
+	WaitSelector = "selector"
+)
+
+// Arguments for CommandTabsCreate.
+type TabsCreateArgs struct {
+	Url string `json:"url"`
+	// Defaults to the current window.
+	WindowId *int `json:"windowId,omitempty"`
+	// Position within the window; defaults to the end.
+	Index *int `json:"index,omitempty"`
+	// Defaults to true.
+	Active *bool `json:"active,omitempty"`
+	Pinned bool  `json:"pinned,omitempty"`
+}
+
+// Arguments for CommandTabsReload.
+type TabsReloadArgs struct {
+	// Reload without using the cache.
+	BypassCache bool `json:"bypassCache,omitempty"`
+}
+
+// Arguments for CommandTabsMove.
+type TabsMoveArgs struct {
+	// Defaults to the tab's current window.
+	WindowId *int `json:"windowId,omitempty"`
+	// Position within the window, or -1 for the end.
+	Index *int `json:"index"`
+}
+
+// Arguments for CommandNavigate.
+type NavigateArgs struct {
+	Url string `json:"url"`
+	// One of the Wait constants.
+	WaitUntil string `json:"waitUntil,omitempty"`
+	// CSS selector, for WaitSelector.
+	Selector string `json:"selector,omitempty"`
+}
+
+// Result of CommandNavigate.
+type NavigateResult struct {
+	// URL after any redirects.
+	Url string `json:"url"`
+	// Status of the page's HTTP response, or 0 if it wasn't loaded over HTTP.
+	HttpStatus int `json:"httpStatus"`
+}
+
+// Arguments for CommandScreenshot.
+type ScreenshotArgs struct {
+	// One of the Image constants; defaults to ImagePng.
+	Format string `json:"format,omitempty"`
+	// From 0 to 100, for ImageJpeg.
+	Quality *int `json:"quality,omitempty"`
+}
+
+// Result of CommandScreenshot.
+type ScreenshotResult struct {
+	Format string `json:"format"`
+	// Base64-encoded image.
+	Data string `json:"data"`
+}
+
+// Cookie in the browser's cookie store.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Set to share a cookie with subdomains; defaults to the URL's host only.
+	Domain string `json:"domain,omitempty"`
+	// Defaults to the URL's path.
+	Path     string `json:"path,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	// "no_restriction", "lax", "strict", or "unspecified".
+	SameSite string `json:"sameSite,omitempty"`
+	// Seconds since the UNIX epoch, or 0 for a session cookie.
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+}
+
+// Arguments for CommandCookiesGet and CommandCookiesRemove.
+type CookiesArgs struct {
+	Url string `json:"url"`
+	// Only includes cookies with this name, if set.
+	Name string `json:"name,omitempty"`
+}
+
+// Arguments for CommandCookiesSet.
+type CookiesSetArgs struct {
+	Url     string   `json:"url"`
+	Cookies []Cookie `json:"cookies"`
+}
+
+// Arguments for CommandStorageGet and CommandStorageClear.
+type StorageArgs struct {
+	// One of the Storage constants; defaults to both.
+	Type string `json:"type,omitempty"`
+}
+
+// Contents of a tab's storage areas. Areas that weren't requested are omitted.
+type StorageSnapshot struct {
+	Local   map[string]string `json:"local,omitempty"`
+	Session map[string]string `json:"session,omitempty"`
+}
+
+// Arguments for CommandDomQuery. Exactly one of Selector and XPath is required.
+type DomQueryArgs struct {
+	// CSS selector.
+	Selector string `json:"selector,omitempty"`
+	// XPath expression that finds elements.
+	XPath string `json:"xpath,omitempty"`
+	// Names of attributes to include.
+	Attributes []string `json:"attributes,omitempty"`
+	// Names of JavaScript properties to include, such as "value" or "checked".
+	Properties []string `json:"properties,omitempty"`
+	// Most elements to return from each tab; defaults to 100.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Element found by CommandDomQuery.
+type Element struct {
+	// Lowercase tag name.
+	Tag string `json:"tag"`
+	// Rendered text, trimmed.
+	Text string `json:"text"`
+	// Requested attributes that the element has.
+	Attributes map[string]string `json:"attributes"`
+	// Requested properties. Values that can't be represented as JSON are null.
+	Properties map[string]any `json:"properties"`
+	// Position relative to the viewport, in CSS pixels.
+	Box Box `json:"box"`
+	// Whether the element takes up space and isn't hidden by CSS.
+	Visible bool `json:"visible"`
+}
+
+// Rectangle in CSS pixels.
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Arguments for CommandInputClick.
+type InputClickArgs struct {
+	// CSS selector of the element; the first match is used.
+	Selector string `json:"selector"`
+	// "left" (default), "middle", or "right".
+	Button string `json:"button,omitempty"`
+	// 1 (default) to 3, such as 2 for a double click.
+	ClickCount int `json:"clickCount,omitempty"`
+}
+
+// Arguments for CommandInputType.
+type InputTypeArgs struct {
+	// CSS selector of the element; defaults to the focused element.
+	Selector string `json:"selector,omitempty"`
+	Text     string `json:"text"`
+	// Milliseconds to wait after each key.
+	Delay float64 `json:"delay,omitempty"`
+	// Removes the element's existing text first.
+	Clear bool `json:"clear,omitempty"`
+}
+
+// Arguments for CommandInputSelect. Exactly one of Values and Labels is required.
+type InputSelectArgs struct {
+	// CSS selector of the select element.
+	Selector string `json:"selector"`
+	// Values of the options to choose; other options are deselected.
+	Values []string `json:"values,omitempty"`
+	// Text of the options to choose, instead of their values.
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Arguments for CommandInputPress.
+type InputPressArgs struct {
+	// CSS selector of the element; defaults to the focused element.
+	Selector string `json:"selector,omitempty"`
+	// Modifiers and a key joined with "+", such as "Control+Shift+K" or "Enter". Keys are named as in
+	// KeyboardEvent.key.
+	Keys string `json:"keys"`
+}
+
+// Arguments for CommandInputScroll. Scrolls the element into view if Selector is set, or the page by
+// X and Y otherwise.
+type InputScrollArgs struct {
+	// CSS selector of the element.
+	Selector string `json:"selector,omitempty"`
+	// "start", "center" (default), "end", or "nearest".
+	Block string  `json:"block,omitempty"`
+	X     float64 `json:"x,omitempty"`
+	Y     float64 `json:"y,omitempty"`
+}
+
+// Result of an input command in one tab.
+type InputResult struct {
+	Success bool `json:"success"`
+	// Why the action failed, such as no element matching the selector.
+	Error string `json:"error,omitempty"`
+	// Element the action was performed on, if any.
+	Element *Element `json:"element,omitempty"`
+}
+
+// Tab returned by tab commands.
+type TabInfo struct {
+	Id       int    `json:"id"`
+	WindowId int    `json:"windowId"`
+	Index    int    `json:"index"`
+	Url      string `json:"url"`
+	Title    string `json:"title"`
+	Active   bool   `json:"active"`
+	Pinned   bool   `json:"pinned"`
+	Audible  bool   `json:"audible"`
+	// "loading" or "complete"
+	Status string `json:"status"`
+}
+
+// Arguments for CommandRun.
+type RunArgs struct {
+	// Body of an async function, which may use statements, await, and return.
+	Script string `json:"script"`
+	// Values passed to the script as its args parameter. Keys can be any string, like args["user-id"].
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// Request for POST /run.
+type RunRequest struct {
+	RunArgs
+	Tabs    *TabSelector   `json:"tabs"`
+	Frame   *FrameSelector `json:"frame"`
+	Timeout float64        `json:"timeout"`
+	Version int            `json:"version"`
+}
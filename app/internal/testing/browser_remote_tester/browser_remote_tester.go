@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,40 +20,88 @@ import (
 )
 
 type TestMessageFromNativeHandler struct {
-	queryListeners *mutex_map.MutexMap[string, chan shared.MessageToBrowser]
+	queryListeners   *mutex_map.MutexMap[string, chan shared.MessageToBrowser]
+	commandListeners *mutex_map.MutexMap[string, chan shared.MessageToBrowser]
+	cancelListeners  *mutex_map.MutexMap[string, chan shared.MessageToBrowser]
 }
 
 func NewTestMessageFromNativeHandler() *TestMessageFromNativeHandler {
 	return &TestMessageFromNativeHandler{
-		queryListeners: mutex_map.New[string, chan shared.MessageToBrowser](),
+		queryListeners:   mutex_map.New[string, chan shared.MessageToBrowser](),
+		commandListeners: mutex_map.New[string, chan shared.MessageToBrowser](),
+		cancelListeners:  mutex_map.New[string, chan shared.MessageToBrowser](),
 	}
 }
 
 func (resp *TestMessageFromNativeHandler) HandleMessage(incomingMsg shared.MessageToBrowser) {
-	listener := resp.queryListeners.Get(incomingMsg.Query)
+	var listener chan shared.MessageToBrowser
+	if incomingMsg.Cancel {
+		listener = resp.cancelListeners.Get(incomingMsg.Id)
+	} else if incomingMsg.Command != shared.CommandEval {
+		listener = resp.commandListeners.Get(incomingMsg.Command)
+	} else {
+		listener = resp.queryListeners.Get(incomingMsg.Query)
+	}
 	if listener != nil {
 		listener <- incomingMsg
 	}
 }
 
+// Timer that fires when the test tells it to. Timers with different durations fire separately.
+// Its clock only moves forward when a timer fires, by that timer's duration.
 type TestTimer struct {
-	timer chan time.Time
+	mutex  sync.Mutex
+	timers map[time.Duration]chan time.Time
+	now    time.Time
 }
 
 func NewTestTimer() *TestTimer {
 	return &TestTimer{
-		timer: make(chan time.Time),
+		mutex:  sync.Mutex{},
+		timers: make(map[time.Duration]chan time.Time),
+		now:    time.Unix(0, 0),
 	}
 }
 
-func (timer *TestTimer) StartTimer(time.Duration) <-chan time.Time {
-	return timer.timer
+func (timer *TestTimer) Now() time.Time {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+	return timer.now
+}
+
+func (timer *TestTimer) StartTimer(dur time.Duration) <-chan time.Time {
+	return timer.timerFor(dur)
 }
 
+// Fires the timer for the default request timeout.
 func (timer *TestTimer) FireTimer() {
-	timer.timer <- time.Now()
+	timer.FireTimerFor(web_server.DefaultTimeout)
+}
+
+// Fires the timer with the given duration, once it's started.
+func (timer *TestTimer) FireTimerFor(dur time.Duration) {
+	ch := timer.timerFor(dur)
+	timer.mutex.Lock()
+	timer.now = timer.now.Add(dur)
+	now := timer.now
+	timer.mutex.Unlock()
+	ch <- now
 }
 
+func (timer *TestTimer) timerFor(dur time.Duration) chan time.Time {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+	ch, ok := timer.timers[dur]
+	if !ok {
+		ch = make(chan time.Time)
+		timer.timers[dur] = ch
+	}
+	return ch
+}
+
+// Token that requests are authenticated with.
+const TestToken = "test-token"
+
 type BrowserRemoteTester struct {
 	logger                   *logger.Logger
 	readerFromBrowser        *io.PipeReader
@@ -82,6 +131,9 @@ func New() *BrowserRemoteTester {
 	messageWriterToNative := native_messaging.NewWriter[shared.MessageFromBrowser](logger, writerToNative, "to native")
 
 	webServer := web_server.New(logger)
+	webServer.RequireToken(TestToken)
+	// used by httptest requests
+	webServer.AllowHosts([]string{"example.com"})
 	webServer.OnMessageReadyForBrowser(func(msg shared.MessageToBrowser) {
 		messageWriterToBrowser.SendMessage(msg)
 	})
@@ -128,17 +180,35 @@ func (br *BrowserRemoteTester) Start() {
 }
 
 func (br *BrowserRemoteTester) SendRequestToWeb(s string) (postDone chan bool, recorder *httptest.ResponseRecorder, timeout *TestTimer) {
-	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(s))
+	return br.SendRequestToWebWithContext(context.Background(), s)
+}
+
+// Sends a request that's cancelled along with ctx, like when the client disconnects.
+func (br *BrowserRemoteTester) SendRequestToWebWithContext(ctx context.Context, s string) (postDone chan bool, recorder *httptest.ResponseRecorder, timeout *TestTimer) {
+	return br.sendToWeb(httptest.NewRequestWithContext(ctx, http.MethodPost, "/", strings.NewReader(s)))
+}
+
+// Sends a GET request for a path, such as "/screenshot?format=png".
+func (br *BrowserRemoteTester) SendGetToWeb(path string) (getDone chan bool, recorder *httptest.ResponseRecorder, timeout *TestTimer) {
+	return br.SendToWeb(http.MethodGet, path, "")
+}
+
+// Sends a request with any method to a path.
+func (br *BrowserRemoteTester) SendToWeb(method string, path string, s string) (done chan bool, recorder *httptest.ResponseRecorder, timeout *TestTimer) {
+	return br.sendToWeb(httptest.NewRequest(method, path, strings.NewReader(s)))
+}
+
+func (br *BrowserRemoteTester) sendToWeb(req *http.Request) (done chan bool, recorder *httptest.ResponseRecorder, timeout *TestTimer) {
+	req.Header.Set("Authorization", "Bearer "+TestToken)
 	timeout = NewTestTimer()
-	ctx := context.WithValue(req.Context(), web_server.TimerKey{}, timeout)
-	req = req.WithContext(ctx)
+	req = req.WithContext(context.WithValue(req.Context(), web_server.TimerKey{}, timeout))
 
 	recorder = httptest.NewRecorder()
 
-	postDone = make(chan bool)
+	done = make(chan bool)
 	go func() {
 		br.webServer.ServeHttp(recorder, req)
-		postDone <- true
+		done <- true
 	}()
 	return
 }
@@ -149,10 +219,30 @@ func (br *BrowserRemoteTester) ListenForQueryToBrowser(s string) chan shared.Mes
 	return ch
 }
 
+func (br *BrowserRemoteTester) ListenForCommandToBrowser(command string) chan shared.MessageToBrowser {
+	ch := make(chan shared.MessageToBrowser)
+	br.messageFromNativeHandler.commandListeners.Set(command, ch)
+	return ch
+}
+
+func (br *BrowserRemoteTester) ListenForCancelToBrowser(id string) chan shared.MessageToBrowser {
+	ch := make(chan shared.MessageToBrowser)
+	br.messageFromNativeHandler.cancelListeners.Set(id, ch)
+	return ch
+}
+
 func (br *BrowserRemoteTester) SendResponseFromBrowser(id string, status string, results []any) {
 	br.messageWriterToNative.SendMessage(shared.MessageFromBrowser{Id: id, Status: status, Results: results})
 }
 
+func (br *BrowserRemoteTester) SendProgressFromBrowser(id string, progress string) {
+	br.messageWriterToNative.SendMessage(shared.MessageFromBrowser{Id: id, Progress: progress})
+}
+
+func (br *BrowserRemoteTester) SendTabResultsFromBrowser(id string, status string, tabResults []shared.TabResult) {
+	br.messageWriterToNative.SendMessage(shared.MessageFromBrowser{Id: id, Status: status, Results: []any{}, TabResults: tabResults})
+}
+
 func (br *BrowserRemoteTester) AssertResponseFromWeb(postDone <-chan bool, recorder *httptest.ResponseRecorder, s string, t *testing.T) {
 	<-postDone
 	resp := recorder.Result()
@@ -1,11 +1,16 @@
 package web_server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/jacobweber/browser_remote/internal/broadcaster"
 	"github.com/jacobweber/browser_remote/internal/logger"
 	"github.com/jacobweber/browser_remote/internal/mutex_map"
 	"github.com/jacobweber/browser_remote/internal/shared"
@@ -15,14 +20,45 @@ import (
 
 type TimerKey struct{}
 
-const browserTimeoutSecs = 5
+const (
+	// How long requests wait for the browser, unless they specify a timeout.
+	DefaultTimeout = 5 * time.Second
+	// Longest timeout a request may specify, unless changed with SetMaxTimeout.
+	DefaultMaxTimeout = 5 * time.Minute
+)
+
+var errTimeout = errors.New("timeout")
+
+// Most time left after the browser stops waiting for a promise, for it to report the timeout.
+const awaitPromiseMargin = 500 * time.Millisecond
+
+// Query sent to the browser, which may send progress messages before its response.
+type pendingQuery struct {
+	messages chan shared.MessageFromBrowser
+	// Closed when the query stops waiting for messages.
+	done chan struct{}
+}
 
 type WebServer struct {
 	logger          *logger.Logger
 	senderToBrowser func(shared.MessageToBrowser)
-	// Map UUIDs of HTTP requests to a channel where we send their browser response.
-	messageFromBrowserHandlers *mutex_map.MutexMap[string, chan shared.MessageFromBrowser]
-	server                     *http.ServeMux
+	// Map UUIDs of HTTP requests to the queries waiting for their browser response.
+	messageFromBrowserHandlers *mutex_map.MutexMap[string, *pendingQuery]
+	// Asynchronous jobs started with POST /jobs.
+	jobs *jobStore
+	// Browser events, sent to clients of GET /events.
+	events *broadcaster.Broadcaster[shared.BrowserEvent]
+	// Longest timeout a request may specify.
+	maxTimeout time.Duration
+	// Token that clients must send, if not empty.
+	token string
+	// Host names that requests may be sent to, if not empty.
+	allowedHosts []string
+	// Origins of web pages that may send requests.
+	allowedOrigins []string
+	server         *http.ServeMux
+	// Server wrapped with middleware.
+	handler http.Handler
 }
 
 func New(logger *logger.Logger) *WebServer {
@@ -30,21 +66,41 @@ func New(logger *logger.Logger) *WebServer {
 	ws := WebServer{
 		logger:                     logger,
 		senderToBrowser:            nil,
-		messageFromBrowserHandlers: mutex_map.New[string, chan shared.MessageFromBrowser](),
+		messageFromBrowserHandlers: mutex_map.New[string, *pendingQuery](),
+		jobs:                       newJobStore(maxJobs, jobTtl),
+		events:                     broadcaster.New[shared.BrowserEvent](eventBufferSize),
+		maxTimeout:                 DefaultMaxTimeout,
+		token:                      "",
+		allowedHosts:               nil,
+		allowedOrigins:             nil,
 		server:                     server,
 	}
 	ws.server.Handle("/", http.HandlerFunc(ws.HandlePost))
+	ws.server.Handle("/jobs", http.HandlerFunc(ws.HandleJobs))
+	ws.server.Handle("/jobs/{id}", http.HandlerFunc(ws.HandleJob))
+	ws.server.Handle("/ws", http.HandlerFunc(ws.HandleWebSocket))
+	ws.server.Handle("/events", http.HandlerFunc(ws.HandleEvents))
+	ws.server.Handle("/screenshot", http.HandlerFunc(ws.HandleScreenshot))
+	ws.server.Handle("/cookies", http.HandlerFunc(ws.HandleCookies))
+	ws.server.Handle("/storage", http.HandlerFunc(ws.HandleStorage))
+	ws.server.Handle("/run", http.HandlerFunc(ws.HandleRun))
+	ws.handler = ws.checkHost(ws.checkOrigin(ws.authenticate(ws.server)))
 	return &ws
 }
 
-func (ws *WebServer) Start(host string, port int) {
+// Serves requests from a listener until it's closed.
+func (ws *WebServer) Serve(listener net.Listener) {
 	go func() {
-		err := http.ListenAndServe(fmt.Sprintf("%v:%v", host, port), ws.server)
-		if err != nil {
-			ws.logger.Error.Printf("Unable to open HTTP server: %v", err)
+		err := http.Serve(listener, ws.handler)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			ws.logger.Error.Printf("Unable to serve HTTP: %v", err)
 		}
 	}()
-	ws.logger.Trace.Printf("Opened HTTP server on http://%v:%v", host, port)
+	ws.logger.Trace.Printf("Serving HTTP on %v", listener.Addr())
+}
+
+func (ws *WebServer) SetMaxTimeout(maxTimeout time.Duration) {
+	ws.maxTimeout = maxTimeout
 }
 
 func (ws *WebServer) OnMessageReadyForBrowser(handler func(shared.MessageToBrowser)) {
@@ -52,17 +108,27 @@ func (ws *WebServer) OnMessageReadyForBrowser(handler func(shared.MessageToBrows
 }
 
 func (ws *WebServer) HandleMessageFromBrowser(incomingMsg shared.MessageFromBrowser) {
-	if incomingMsg.Id != "" {
-		responder := ws.messageFromBrowserHandlers.Get(incomingMsg.Id)
-		if responder != nil {
+	if incomingMsg.Event != nil {
+		ws.logger.Trace.Printf("Event received from browser: %v", incomingMsg.Event.Type)
+		dropped := ws.events.Publish(*incomingMsg.Event)
+		if dropped > 0 {
+			ws.logger.Error.Printf("Dropped event for %v slow clients", dropped)
+		}
+	} else if incomingMsg.Id != "" {
+		query := ws.messageFromBrowserHandlers.Get(incomingMsg.Id)
+		if query != nil {
 			ws.logger.Trace.Printf("Message received from browser for ID: %v", incomingMsg.Id)
-			responder <- incomingMsg
+			// don't block if the request already gave up waiting
+			select {
+			case query.messages <- incomingMsg:
+			case <-query.done:
+			}
 		}
 	}
 }
 
 func (ws *WebServer) ServeHttp(w http.ResponseWriter, req *http.Request) {
-	ws.server.ServeHTTP(w, req)
+	ws.handler.ServeHTTP(w, req)
 }
 
 func (ws *WebServer) HandlePost(w http.ResponseWriter, req *http.Request) {
@@ -78,43 +144,208 @@ func (ws *WebServer) HandlePost(w http.ResponseWriter, req *http.Request) {
 	}
 
 	ws.logger.Trace.Printf("Got POST request")
+	msg, ok := ws.decodeRequest(w, req)
+	if !ok {
+		return
+	}
+
+	ws.respondToRequest(w, req, msg)
+}
+
+// Runs a valid request in the browser, and responds with its result.
+func (ws *WebServer) respondToRequest(w http.ResponseWriter, req *http.Request, msg shared.MessageToWebServer) {
+	response, err := ws.runRequest(req.Context(), msg, ws.requestTimeout(msg, DefaultTimeout))
+	if err != nil {
+		if req.Context().Err() != nil {
+			// client has gone away
+			return
+		}
+		respondJson(w, http.StatusInternalServerError, response.message())
+		return
+	}
+	respondJson(w, http.StatusOK, response.message())
+}
+
+// Runs a command for an endpoint other than POST /, with args, after checking that it's valid.
+func (ws *WebServer) respondToCommand(w http.ResponseWriter, req *http.Request, msg shared.MessageToWebServer, args any) {
+	var err error
+	if raw, ok := args.(json.RawMessage); ok {
+		msg.Args = raw
+	} else {
+		msg.Args, err = json.Marshal(args)
+	}
+	if err == nil {
+		err = ws.validateRequest(msg)
+	}
+	if err != nil {
+		ws.logger.Error.Printf("Invalid %v request: %v", msg.Command, err)
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+	ws.respondToRequest(w, req, msg)
+}
+
+// Returns a request for a command, with the tab and timeout from query parameters of an endpoint
+// other than POST /.
+func paramsRequest(req *http.Request, command string) (shared.MessageToWebServer, error) {
+	params := req.URL.Query()
+	msg := shared.MessageToWebServer{Command: command}
+	if param := params.Get("tab"); param != "" {
+		tabId, err := strconv.Atoi(param)
+		if err != nil {
+			return msg, fmt.Errorf("invalid tab: %q is not a number", param)
+		}
+		msg.Tabs = &shared.TabSelector{TabIds: []int{tabId}}
+	}
+	if param := params.Get("timeout"); param != "" {
+		timeout, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return msg, fmt.Errorf("invalid timeout: %q is not a number", param)
+		}
+		msg.Timeout = timeout
+	}
+	return msg, nil
+}
+
+// Decodes a request body, or responds with an error.
+func (ws *WebServer) decodeRequest(w http.ResponseWriter, req *http.Request) (shared.MessageToWebServer, bool) {
 	var msg shared.MessageToWebServer
 	decoder := json.NewDecoder(req.Body)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&msg)
 	if err != nil {
 		ws.logger.Error.Printf("Error parsing POST request: %v", err)
-		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: "invalid JSON", Results: []any{}})
-		return
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: "invalid JSON: " + err.Error(), Results: []any{}})
+		return msg, false
+	}
+	err = ws.validateRequest(msg)
+	if err != nil {
+		ws.logger.Error.Printf("Invalid POST request: %v", err)
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return msg, false
+	}
+	return msg, true
+}
+
+// Checks that a request's fields are valid.
+func (ws *WebServer) validateRequest(msg shared.MessageToWebServer) error {
+	if msg.Timeout < 0 || time.Duration(msg.Timeout*float64(time.Second)) > ws.maxTimeout {
+		return fmt.Errorf("invalid timeout: must be between 0 and %v seconds", ws.maxTimeout.Seconds())
+	}
+	if msg.Version != 0 && msg.Version != ResponseV1 && msg.Version != ResponseV2 {
+		return fmt.Errorf("invalid version: must be %v or %v", ResponseV1, ResponseV2)
+	}
+	err := validateCommand(msg)
+	if err != nil {
+		return err
+	}
+	return validateWaitFor(msg)
+}
+
+// Returns how long to wait for the browser to respond to a request.
+func (ws *WebServer) requestTimeout(msg shared.MessageToWebServer, defaultTimeout time.Duration) time.Duration {
+	if msg.Timeout > 0 {
+		return time.Duration(msg.Timeout * float64(time.Second))
+	}
+	spec := commands[requestCommand(msg)]
+	if spec.defaultTimeout > defaultTimeout {
+		return min(spec.defaultTimeout, ws.maxTimeout)
+	}
+	return defaultTimeout
+}
+
+// Returns the timer for a request, which tests may replace.
+func timerFrom(ctx context.Context) shared.Timer {
+	timer, ok := ctx.Value(TimerKey{}).(shared.Timer)
+	if !ok {
+		return &shared.RealTimer{}
+	}
+	return timer
+}
+
+// Runs a request in the browser, and returns its response in the format requested. If it fails,
+// also returns a response with the error as its status.
+func (ws *WebServer) runRequest(ctx context.Context, msg shared.MessageToWebServer, timeout time.Duration) (response, error) {
+	if msg.WaitFor != nil {
+		return ws.waitFor(ctx, msg, timeout)
+	}
+	messageFromBrowser, err := ws.queryBrowser(ctx, msg, timeout)
+	if err != nil {
+		return emptyResponse(msg, err.Error()), err
+	}
+	if spec := commands[requestCommand(msg)]; spec.capResponse != nil {
+		spec.capResponse(msg, &messageFromBrowser)
 	}
+	return buildResponse(msg, messageFromBrowser), nil
+}
 
+// Sends a query to the browser, and waits for its response, a timeout, or for ctx to be cancelled.
+// If the browser doesn't respond, tells it to cancel the query.
+func (ws *WebServer) queryBrowser(ctx context.Context, msg shared.MessageToWebServer, timeout time.Duration) (shared.MessageFromBrowser, error) {
 	// send message to browser with a random ID, and listen for messages from browser with that ID
 	uuid := uuid.NewString()
-	messageFromBrowserHandler := make(chan shared.MessageFromBrowser)
-	ws.messageFromBrowserHandlers.Set(uuid, messageFromBrowserHandler)
+	query := &pendingQuery{
+		messages: make(chan shared.MessageFromBrowser),
+		done:     make(chan struct{}),
+	}
+	ws.messageFromBrowserHandlers.Set(uuid, query)
+	defer close(query.done)
 	defer ws.messageFromBrowserHandlers.Delete(uuid)
 	if ws.senderToBrowser != nil {
-		ws.senderToBrowser(shared.MessageToBrowser{Id: uuid, Query: msg.Query, Tabs: msg.Tabs})
+		messageToBrowser := shared.MessageToBrowser{
+			Id:      uuid,
+			Command: requestCommand(msg),
+			Query:   msg.Query,
+			Args:    msg.Args,
+			Tabs:    requestTabs(msg),
+			Frame:   msg.Frame,
+		}
+		if msg.AwaitPromise {
+			messageToBrowser.AwaitPromise = true
+			messageToBrowser.AwaitTimeout = float64(awaitTimeout(timeout).Milliseconds())
+		}
+		ws.senderToBrowser(messageToBrowser)
 	}
 
-	var timer shared.Timer
-	timer, ok := req.Context().Value(TimerKey{}).(shared.Timer)
-	if !ok {
-		timer = &shared.RealTimer{}
+	timer := timerFrom(ctx)
+
+	// wait for a browser response or a timeout, across any progress messages
+	timeoutChan := timer.StartTimer(timeout)
+	for {
+		select {
+		case messageFromBrowser := <-query.messages:
+			if messageFromBrowser.Progress != "" {
+				ws.logger.Trace.Printf("Progress for request ID %v: %v", uuid, messageFromBrowser.Progress)
+				continue
+			}
+			return messageFromBrowser, nil
+		case <-timeoutChan:
+			ws.logger.Error.Printf("Timeout responding to request ID %v", uuid)
+			ws.cancelQuery(uuid)
+			return shared.MessageFromBrowser{}, errTimeout
+		case <-ctx.Done():
+			ws.logger.Error.Printf("Cancelled request ID %v", uuid)
+			ws.cancelQuery(uuid)
+			return shared.MessageFromBrowser{}, ctx.Err()
+		}
 	}
+}
 
-	// wait for a browser message or a timeout
-	select {
-	case messageFromBrowser := <-messageFromBrowserHandler:
-		respondJson(w, http.StatusOK, shared.MessageFromWebServer{Status: messageFromBrowser.Status, Results: messageFromBrowser.Results})
-	case <-timer.StartTimer(browserTimeoutSecs * time.Second):
-		ws.logger.Error.Printf("Timeout responding to request ID %v", uuid)
-		respondJson(w, http.StatusInternalServerError, shared.MessageFromWebServer{Status: "timeout", Results: []any{}})
+// Returns how long the browser may wait for a promise, leaving time for it to report a timeout
+// before the request itself times out.
+func awaitTimeout(timeout time.Duration) time.Duration {
+	return timeout - min(awaitPromiseMargin, timeout/10)
+}
+
+// Tells the browser to stop working on a query.
+func (ws *WebServer) cancelQuery(uuid string) {
+	if ws.senderToBrowser != nil {
+		ws.senderToBrowser(shared.MessageToBrowser{Id: uuid, Cancel: true})
 	}
 }
 
-func respondJson(w http.ResponseWriter, statusCode int, msg shared.MessageFromWebServer) {
-	w.WriteHeader(statusCode)
+func respondJson(w http.ResponseWriter, statusCode int, msg any) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(msg)
 }
@@ -0,0 +1,43 @@
+package web_server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Generates a random token for clients to authenticate with.
+func GenerateToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Requires clients to send "Authorization: Bearer <token>" with every request. An empty token
+// allows all requests.
+func (ws *WebServer) RequireToken(token string) {
+	ws.token = token
+}
+
+// Rejects requests without the required token.
+func (ws *WebServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ws.token != "" {
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ws.token)) != 1 {
+				ws.logger.Error.Printf("Unauthorized request for %v", req.URL.Path)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				respondJson(w, http.StatusUnauthorized, shared.MessageFromWebServer{Status: "unauthorized", Results: []any{}})
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
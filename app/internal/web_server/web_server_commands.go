@@ -0,0 +1,236 @@
+package web_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// How a command's request is validated and sent to the browser.
+type commandSpec struct {
+	// Checks the command's arguments; nil if it doesn't take any.
+	validateArgs func(args json.RawMessage) error
+	// Whether the command is sent to the tabs in the request.
+	usesTabs bool
+	// Tabs to use if the request doesn't specify them.
+	defaultTabs func() shared.TabSelector
+	// Whether the command runs in the content script of each frame, so the request may select frames.
+	usesFrames bool
+	// How long to wait for the browser, if longer than usual and the request doesn't specify a timeout.
+	defaultTimeout time.Duration
+	// Trims the browser's response to what the request allows, if set.
+	capResponse func(msg shared.MessageToWebServer, messageFromBrowser *shared.MessageFromBrowser)
+}
+
+// How long to wait for navigations by default, since pages may be slow to load.
+const navigateTimeout = 30 * time.Second
+
+var commands = map[string]commandSpec{
+	shared.CommandEval:          {usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandTabsList:      {usesTabs: true, defaultTabs: shared.AllTabs},
+	shared.CommandTabsCreate:    {validateArgs: argsValidator(validateTabsCreateArgs)},
+	shared.CommandTabsClose:     {usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandTabsActivate:  {usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandTabsReload:    {validateArgs: argsValidator(func(shared.TabsReloadArgs) error { return nil }), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandTabsMove:      {validateArgs: argsValidator(validateTabsMoveArgs), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandNavigate:      {validateArgs: argsValidator(validateNavigateArgs), usesTabs: true, defaultTabs: shared.FrontTabs, defaultTimeout: navigateTimeout},
+	shared.CommandScreenshot:    {validateArgs: argsValidator(validateScreenshotArgs), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandCookiesGet:    {validateArgs: argsValidator(validateCookiesArgs)},
+	shared.CommandCookiesSet:    {validateArgs: argsValidator(validateCookiesSetArgs)},
+	shared.CommandCookiesRemove: {validateArgs: argsValidator(validateCookiesArgs)},
+	shared.CommandStorageGet:    {validateArgs: argsValidator(validateStorageArgs), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandStorageSet:    {validateArgs: argsValidator(func(shared.StorageSnapshot) error { return nil }), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandStorageClear:  {validateArgs: argsValidator(validateStorageArgs), usesTabs: true, defaultTabs: shared.FrontTabs},
+	shared.CommandDomQuery:      {validateArgs: argsValidator(validateDomQueryArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true, capResponse: capDomQueryResponse},
+	shared.CommandInputClick:    {validateArgs: argsValidator(validateInputClickArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandInputType:     {validateArgs: argsValidator(validateInputTypeArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandInputSelect:   {validateArgs: argsValidator(validateInputSelectArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandInputPress:    {validateArgs: argsValidator(validateInputPressArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandInputScroll:   {validateArgs: argsValidator(validateInputScrollArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+	shared.CommandRun:           {validateArgs: argsValidator(validateRunArgs), usesTabs: true, defaultTabs: shared.FrontTabs, usesFrames: true},
+}
+
+// Returns a function that decodes arguments into A, rejecting unknown fields, and checks them with validate.
+func argsValidator[A any](validate func(A) error) func(json.RawMessage) error {
+	return func(raw json.RawMessage) error {
+		var args A
+		if len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+			decoder := json.NewDecoder(bytes.NewReader(raw))
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&args)
+			if err != nil {
+				return fmt.Errorf("invalid args: %w", err)
+			}
+		}
+		err := validate(args)
+		if err != nil {
+			return fmt.Errorf("invalid args: %w", err)
+		}
+		return nil
+	}
+}
+
+func validateTabsCreateArgs(args shared.TabsCreateArgs) error {
+	if args.Url == "" {
+		return errors.New("url is required")
+	}
+	if args.WindowId != nil && *args.WindowId < 0 {
+		return fmt.Errorf("windowId: %d is negative", *args.WindowId)
+	}
+	if args.Index != nil && *args.Index < 0 {
+		return fmt.Errorf("index: %d is negative", *args.Index)
+	}
+	return nil
+}
+
+func validateTabsMoveArgs(args shared.TabsMoveArgs) error {
+	if args.Index == nil {
+		return errors.New("index is required")
+	}
+	if *args.Index < -1 {
+		return fmt.Errorf("index: %d must be -1 or more", *args.Index)
+	}
+	if args.WindowId != nil && *args.WindowId < 0 {
+		return fmt.Errorf("windowId: %d is negative", *args.WindowId)
+	}
+	return nil
+}
+
+func validateNavigateArgs(args shared.NavigateArgs) error {
+	if args.Url == "" {
+		return errors.New("url is required")
+	}
+	switch args.WaitUntil {
+	case "", shared.WaitDomContentLoaded, shared.WaitLoad, shared.WaitNetworkIdle:
+		if args.Selector != "" {
+			return fmt.Errorf("selector: only used when waitUntil is %q", shared.WaitSelector)
+		}
+	case shared.WaitSelector:
+		if args.Selector == "" {
+			return errors.New("selector is required")
+		}
+	default:
+		return fmt.Errorf("waitUntil: must be %q, %q, %q, or %q", shared.WaitDomContentLoaded, shared.WaitLoad, shared.WaitNetworkIdle, shared.WaitSelector)
+	}
+	return nil
+}
+
+func validateScreenshotArgs(args shared.ScreenshotArgs) error {
+	switch args.Format {
+	case "", shared.ImagePng:
+		if args.Quality != nil {
+			return fmt.Errorf("quality: only used when format is %q", shared.ImageJpeg)
+		}
+	case shared.ImageJpeg:
+		if args.Quality != nil && (*args.Quality < 0 || *args.Quality > 100) {
+			return errors.New("quality: must be between 0 and 100")
+		}
+	default:
+		return fmt.Errorf("format: must be %q or %q", shared.ImagePng, shared.ImageJpeg)
+	}
+	return nil
+}
+
+// Checks that a URL can have cookies.
+func validateCookieUrl(rawUrl string) error {
+	if rawUrl == "" {
+		return errors.New("url is required")
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url: %q is not an HTTP or HTTPS URL", rawUrl)
+	}
+	return nil
+}
+
+func validateCookiesArgs(args shared.CookiesArgs) error {
+	return validateCookieUrl(args.Url)
+}
+
+func validateCookiesSetArgs(args shared.CookiesSetArgs) error {
+	err := validateCookieUrl(args.Url)
+	if err != nil {
+		return err
+	}
+	if len(args.Cookies) == 0 {
+		return errors.New("cookies are required")
+	}
+	for i, cookie := range args.Cookies {
+		if cookie.Name == "" {
+			return fmt.Errorf("cookies[%d]: name is required", i)
+		}
+		switch cookie.SameSite {
+		case "", "no_restriction", "lax", "strict", "unspecified":
+		default:
+			return fmt.Errorf("cookies[%d]: sameSite: must be \"no_restriction\", \"lax\", \"strict\", or \"unspecified\"", i)
+		}
+		if cookie.ExpirationDate < 0 {
+			return fmt.Errorf("cookies[%d]: expirationDate: must not be negative", i)
+		}
+	}
+	return nil
+}
+
+func validateStorageArgs(args shared.StorageArgs) error {
+	if args.Type != "" && args.Type != shared.StorageLocal && args.Type != shared.StorageSession {
+		return fmt.Errorf("type: must be %q or %q", shared.StorageLocal, shared.StorageSession)
+	}
+	return nil
+}
+
+// Returns the name of the command a request runs.
+func requestCommand(msg shared.MessageToWebServer) string {
+	if msg.Command == "" {
+		return shared.CommandEval
+	}
+	return msg.Command
+}
+
+// Checks that a request's command, arguments, and tabs are valid.
+func validateCommand(msg shared.MessageToWebServer) error {
+	command := requestCommand(msg)
+	spec, ok := commands[command]
+	if !ok {
+		return fmt.Errorf("invalid command: %q", msg.Command)
+	}
+	if command != shared.CommandEval && msg.Query != "" {
+		return fmt.Errorf("invalid query: not used by %v", command)
+	}
+	if command != shared.CommandEval && msg.AwaitPromise {
+		return fmt.Errorf("invalid awaitPromise: not used by %v", command)
+	}
+	if spec.validateArgs == nil {
+		if len(msg.Args) > 0 && !bytes.Equal(msg.Args, []byte("null")) {
+			return fmt.Errorf("invalid args: not used by %v", command)
+		}
+	} else {
+		err := spec.validateArgs(msg.Args)
+		if err != nil {
+			return err
+		}
+	}
+	if msg.Frame != nil {
+		if !spec.usesFrames {
+			return fmt.Errorf("invalid frame: not used by %v", command)
+		}
+		err := validateFrameSelector(*msg.Frame)
+		if err != nil {
+			return err
+		}
+	}
+	if !spec.usesTabs {
+		if msg.Tabs != nil {
+			return fmt.Errorf("invalid tabs: not used by %v", command)
+		}
+		return nil
+	}
+	return validateTabSelector(requestTabs(msg))
+}
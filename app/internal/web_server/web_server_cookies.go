@@ -0,0 +1,60 @@
+package web_server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Most bytes accepted in the body of a PUT request.
+const maxPutSize = 1024 * 1024
+
+// Lists cookies for a URL with GET /cookies?url=...&name=..., sets them with PUT /cookies, or deletes
+// them with DELETE /cookies?url=...&name=...
+func (ws *WebServer) HandleCookies(w http.ResponseWriter, req *http.Request) {
+	var command string
+	switch req.Method {
+	case "GET":
+		command = shared.CommandCookiesGet
+	case "PUT":
+		command = shared.CommandCookiesSet
+	case "DELETE":
+		command = shared.CommandCookiesRemove
+	default:
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+
+	msg, err := paramsRequest(req, command)
+	if err != nil {
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+	if command == shared.CommandCookiesSet {
+		body, ok := ws.readPutBody(w, req)
+		if ok {
+			ws.respondToCommand(w, req, msg, body)
+		}
+		return
+	}
+	params := req.URL.Query()
+	ws.respondToCommand(w, req, msg, shared.CookiesArgs{Url: params.Get("url"), Name: params.Get("name")})
+}
+
+// Reads the body of a PUT request, to be used as a command's arguments.
+func (ws *WebServer) readPutBody(w http.ResponseWriter, req *http.Request) (json.RawMessage, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPutSize))
+	if err != nil {
+		ws.logger.Error.Printf("Error reading PUT request: %v", err)
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: "invalid body: " + err.Error(), Results: []any{}})
+		return nil, false
+	}
+	if !json.Valid(body) {
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: "invalid JSON", Results: []any{}})
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,154 @@
+package web_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+const (
+	// Most elements dom.query returns from each tab, unless it specifies a limit.
+	DefaultDomQueryLimit = 100
+	// Highest limit dom.query may specify.
+	MaxDomQueryLimit = 1000
+)
+
+var (
+	attributeNameRegexp = regexp.MustCompile(`^[^\s"'>/=]+$`)
+	propertyNameRegexp  = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+)
+
+func validateDomQueryArgs(args shared.DomQueryArgs) error {
+	if (args.Selector == "") == (args.XPath == "") {
+		return errors.New("one of selector or xpath is required")
+	}
+	if args.Selector != "" {
+		err := checkCssSelector(args.Selector)
+		if err != nil {
+			return fmt.Errorf("selector: %w", err)
+		}
+	} else {
+		// XPath string literals have no escapes
+		_, err := scanBrackets(args.XPath, false)
+		if err != nil {
+			return fmt.Errorf("xpath: %w", err)
+		}
+	}
+	for _, name := range args.Attributes {
+		if !attributeNameRegexp.MatchString(name) {
+			return fmt.Errorf("attributes: %q is not a valid attribute name", name)
+		}
+	}
+	for _, name := range args.Properties {
+		if !propertyNameRegexp.MatchString(name) {
+			return fmt.Errorf("properties: %q is not a valid property name", name)
+		}
+	}
+	if args.Limit < 0 || args.Limit > MaxDomQueryLimit {
+		return fmt.Errorf("limit: must be between 0 and %d", MaxDomQueryLimit)
+	}
+	return nil
+}
+
+// Trims the elements from each tab or frame to the request's limit, in case the browser returned more.
+func capDomQueryResponse(msg shared.MessageToWebServer, messageFromBrowser *shared.MessageFromBrowser) {
+	var args shared.DomQueryArgs
+	// already validated
+	json.Unmarshal(msg.Args, &args)
+	limit := args.Limit
+	if limit == 0 {
+		limit = DefaultDomQueryLimit
+	}
+	capElements := func(value any) any {
+		if elements, ok := value.([]any); ok && len(elements) > limit {
+			return elements[:limit]
+		}
+		return value
+	}
+	for i := range messageFromBrowser.Results {
+		messageFromBrowser.Results[i] = capElements(messageFromBrowser.Results[i])
+	}
+	for i := range messageFromBrowser.TabResults {
+		tabResult := &messageFromBrowser.TabResults[i]
+		tabResult.Value = capElements(tabResult.Value)
+		for j := range tabResult.Frames {
+			tabResult.Frames[j].Value = capElements(tabResult.Frames[j].Value)
+		}
+	}
+}
+
+// Catches common mistakes in a CSS selector. Browsers support more syntax than is worth parsing here,
+// so anything else is left for them to reject.
+func checkCssSelector(selector string) error {
+	commas, err := scanBrackets(selector, true)
+	if err != nil {
+		return err
+	}
+	start := 0
+	for _, end := range append(commas, len(selector)) {
+		part := strings.TrimSpace(selector[start:end])
+		start = end + 1
+		if part == "" {
+			return errors.New("empty selector in list")
+		}
+		if strings.ContainsAny(part[len(part)-1:], ">+~") && !isEscaped(part, len(part)-1) {
+			return fmt.Errorf("%q ends with a combinator", part)
+		}
+	}
+	return nil
+}
+
+// Returns whether the character at index i of a CSS selector is escaped by a backslash.
+func isEscaped(selector string, i int) bool {
+	backslashes := 0
+	for i--; i >= 0 && selector[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// Checks that brackets and quotes in an expression are balanced, and returns the positions of commas
+// outside of them. If escapes is set, a backslash escapes the character after it, as in CSS.
+func scanBrackets(expr string, escapes bool) ([]int, error) {
+	closing := map[rune]rune{'(': ')', '[': ']'}
+	var open []rune
+	var quote rune
+	var commas []int
+	escaped := false
+	for i, c := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' && escapes {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '\\' && escapes:
+			escaped = true
+		case c == ',' && len(open) == 0:
+			commas = append(commas, i)
+		case c == '(' || c == '[':
+			open = append(open, closing[c])
+		case c == ')' || c == ']':
+			if len(open) == 0 || open[len(open)-1] != c {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed %q", quote)
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("missing %q", open[len(open)-1])
+	}
+	return commas, nil
+}
@@ -0,0 +1,74 @@
+package web_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+const (
+	// Number of events buffered for each client before they're dropped.
+	eventBufferSize = 100
+	// How often to send a comment, so proxies and clients don't close idle streams.
+	eventKeepAliveInterval = 15 * time.Second
+)
+
+// Streams browser events as Server-Sent Events with GET /events. Clients can pass
+// ?types=tab.created,tab.removed to only receive some types of events.
+func (ws *WebServer) HandleEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ws.logger.Error.Printf("Streaming not supported")
+		respondJson(w, http.StatusInternalServerError, shared.MessageFromWebServer{Status: "streaming not supported", Results: []any{}})
+		return
+	}
+
+	var types []string
+	if param := req.URL.Query().Get("types"); param != "" {
+		types = strings.Split(param, ",")
+	}
+
+	events, unsubscribe := ws.events.Subscribe()
+	defer unsubscribe()
+	ws.logger.Trace.Printf("Opened event stream")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			if types != nil && !slices.Contains(types, event.Type) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				ws.logger.Error.Printf("Unable to marshal event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			ws.logger.Trace.Printf("Closed event stream")
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package web_server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Longest delay input.type may wait after each key, in milliseconds.
+const maxTypeDelay = 10000
+
+var modifierKeys = []string{"Alt", "Control", "Meta", "Shift"}
+
+// Checks a selector, which is required unless optional is set.
+func validateInputSelector(selector string, optional bool) error {
+	if selector == "" {
+		if optional {
+			return nil
+		}
+		return errors.New("selector is required")
+	}
+	err := checkCssSelector(selector)
+	if err != nil {
+		return fmt.Errorf("selector: %w", err)
+	}
+	return nil
+}
+
+func validateInputClickArgs(args shared.InputClickArgs) error {
+	err := validateInputSelector(args.Selector, false)
+	if err != nil {
+		return err
+	}
+	switch args.Button {
+	case "", "left", "middle", "right":
+	default:
+		return errors.New(`button: must be "left", "middle", or "right"`)
+	}
+	if args.ClickCount < 0 || args.ClickCount > 3 {
+		return errors.New("clickCount: must be between 1 and 3")
+	}
+	return nil
+}
+
+func validateInputTypeArgs(args shared.InputTypeArgs) error {
+	err := validateInputSelector(args.Selector, true)
+	if err != nil {
+		return err
+	}
+	if args.Text == "" && !args.Clear {
+		return errors.New("text is required")
+	}
+	if args.Delay < 0 || args.Delay > maxTypeDelay {
+		return fmt.Errorf("delay: must be between 0 and %d milliseconds", maxTypeDelay)
+	}
+	return nil
+}
+
+func validateInputSelectArgs(args shared.InputSelectArgs) error {
+	err := validateInputSelector(args.Selector, false)
+	if err != nil {
+		return err
+	}
+	if (args.Values == nil) == (args.Labels == nil) {
+		return errors.New("one of values or labels is required")
+	}
+	return nil
+}
+
+func validateInputPressArgs(args shared.InputPressArgs) error {
+	err := validateInputSelector(args.Selector, true)
+	if err != nil {
+		return err
+	}
+	return validateKeyChord(args.Keys)
+}
+
+// Checks that keys are modifiers followed by one key, like "Control+Shift+K".
+func validateKeyChord(keys string) error {
+	if keys == "" {
+		return errors.New("keys is required")
+	}
+	// "+" is a key too, so "Shift++" presses it with Shift
+	parts := strings.Split(keys, "+")
+	if strings.HasSuffix(keys, "++") || keys == "+" {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+	key := parts[len(parts)-1]
+	if key == "" {
+		return fmt.Errorf("keys: %q is missing a key after the modifiers", keys)
+	}
+	var seen []string
+	for _, modifier := range parts[:len(parts)-1] {
+		if !slices.Contains(modifierKeys, modifier) {
+			return fmt.Errorf("keys: %q is not a modifier; must be one of %v", modifier, strings.Join(modifierKeys, ", "))
+		}
+		if slices.Contains(seen, modifier) {
+			return fmt.Errorf("keys: %q is repeated", modifier)
+		}
+		seen = append(seen, modifier)
+	}
+	return nil
+}
+
+func validateInputScrollArgs(args shared.InputScrollArgs) error {
+	err := validateInputSelector(args.Selector, true)
+	if err != nil {
+		return err
+	}
+	switch args.Block {
+	case "", "start", "center", "end", "nearest":
+	default:
+		return errors.New(`block: must be "start", "center", "end", or "nearest"`)
+	}
+	if args.Selector != "" && (args.X != 0 || args.Y != 0) {
+		return errors.New("x and y: only used without a selector")
+	}
+	if args.Selector == "" && args.X == 0 && args.Y == 0 {
+		return errors.New("one of selector, x, or y is required")
+	}
+	if args.Selector == "" && args.Block != "" {
+		return errors.New("block: only used with a selector")
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package web_server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Maximum number of jobs kept in memory, including pending ones.
+	maxJobs = 1000
+	// How long results are kept after a job finishes.
+	jobTtl = 10 * time.Minute
+)
+
+const (
+	JobPending   = "pending"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+var errTooManyJobs = errors.New("too many jobs")
+
+type job struct {
+	id     string
+	status string
+	error  string
+	// Results once the job finishes, in the version its request asked for.
+	response response
+	cancel   context.CancelFunc
+	// When the job can be evicted; zero while pending.
+	expires time.Time
+}
+
+// Returns the job's state to send to clients.
+func (j job) message() any {
+	if j.response.v2 != nil {
+		return shared.JobFromWebServerV2{Id: j.id, Status: j.status, Error: j.error, Results: j.response.v2.Results}
+	}
+	return shared.JobFromWebServer{Id: j.id, Status: j.status, Error: j.error, Results: j.response.v1.Results}
+}
+
+// Bounded store of jobs, which evicts finished jobs after a TTL.
+type jobStore struct {
+	mutex   sync.Mutex
+	jobs    map[string]*job
+	maxJobs int
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newJobStore(maxJobs int, ttl time.Duration) *jobStore {
+	return &jobStore{
+		mutex:   sync.Mutex{},
+		jobs:    make(map[string]*job),
+		maxJobs: maxJobs,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// Adds a pending job for a request, evicting the oldest finished job if the store is full.
+func (s *jobStore) add(id string, msg shared.MessageToWebServer, cancel context.CancelFunc) (job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	if len(s.jobs) >= s.maxJobs {
+		oldestId := ""
+		for id, j := range s.jobs {
+			if !j.expires.IsZero() && (oldestId == "" || j.expires.Before(s.jobs[oldestId].expires)) {
+				oldestId = id
+			}
+		}
+		if oldestId == "" {
+			return job{}, errTooManyJobs
+		}
+		delete(s.jobs, oldestId)
+	}
+	j := &job{
+		id:       id,
+		status:   JobPending,
+		response: emptyResponse(msg, ""),
+		cancel:   cancel,
+	}
+	s.jobs[id] = j
+	return *j, nil
+}
+
+func (s *jobStore) get(id string) (job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// Records the outcome of a pending job, with its response if it has results; jobs that already
+// finished are left alone.
+func (s *jobStore) finish(id string, status string, errorMsg string, response *response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j, ok := s.jobs[id]
+	if !ok || j.status != JobPending {
+		return
+	}
+	j.status = status
+	j.error = errorMsg
+	if response != nil {
+		j.response = *response
+	}
+	j.expires = s.now().Add(s.ttl)
+}
+
+// Cancels a pending job, or removes a finished one.
+func (s *jobStore) cancel(id string) (job, bool) {
+	s.mutex.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mutex.Unlock()
+		return job{}, false
+	}
+	if j.status != JobPending {
+		delete(s.jobs, id)
+		s.mutex.Unlock()
+		return *j, true
+	}
+	s.mutex.Unlock()
+
+	s.finish(id, JobCancelled, "", nil)
+	j.cancel()
+	return s.get(id)
+}
+
+// Removes expired jobs. Must be called with the mutex held.
+func (s *jobStore) prune() {
+	now := s.now()
+	for id, j := range s.jobs {
+		if !j.expires.IsZero() && !now.Before(j.expires) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// Starts a job with POST /jobs.
+func (ws *WebServer) HandleJobs(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+
+	ws.logger.Trace.Printf("Got job request")
+	msg, ok := ws.decodeRequest(w, req)
+	if !ok {
+		return
+	}
+
+	// keep request values like the timer, but let the job outlive the request
+	ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	id := uuid.NewString()
+	state, err := ws.jobs.add(id, msg, cancel)
+	if err != nil {
+		cancel()
+		ws.logger.Error.Printf("Unable to start job: %v", err)
+		respondJson(w, http.StatusServiceUnavailable, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+
+	go func() {
+		defer cancel()
+		response, err := ws.runRequest(ctx, msg, ws.requestTimeout(msg, ws.maxTimeout))
+		if err != nil {
+			ws.jobs.finish(id, JobFailed, err.Error(), nil)
+			return
+		}
+		if response.status() != "ok" {
+			ws.jobs.finish(id, JobFailed, response.status(), &response)
+		} else {
+			ws.jobs.finish(id, JobDone, "", &response)
+		}
+	}()
+
+	respondJson(w, http.StatusAccepted, state.message())
+}
+
+// Polls a job with GET /jobs/{id}, or cancels it with DELETE /jobs/{id}.
+func (ws *WebServer) HandleJob(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	var state job
+	var ok bool
+	switch req.Method {
+	case "GET":
+		state, ok = ws.jobs.get(id)
+	case "DELETE":
+		ws.logger.Trace.Printf("Cancelling job %v", id)
+		state, ok = ws.jobs.cancel(id)
+	default:
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+	if !ok {
+		ws.logger.Error.Printf("Invalid job %v", id)
+		respondJson(w, http.StatusNotFound, shared.MessageFromWebServer{Status: "not found", Results: []any{}})
+		return
+	}
+	respondJson(w, http.StatusOK, state.message())
+}
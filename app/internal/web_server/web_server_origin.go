@@ -0,0 +1,84 @@
+package web_server
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Host names that always refer to this machine.
+var LocalHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type"
+	corsMaxAgeSecs     = "600"
+)
+
+// Only accepts requests whose Host header has one of these names, with any port. This prevents
+// DNS rebinding attacks, where a malicious site's host name resolves to this machine. An empty
+// list allows all hosts.
+func (ws *WebServer) AllowHosts(hosts []string) {
+	ws.allowedHosts = nil
+	for _, host := range hosts {
+		ws.allowedHosts = append(ws.allowedHosts, strings.ToLower(host))
+	}
+}
+
+// Allows requests from web pages with these origins, like "https://example.com". Requests from
+// other web pages are rejected, to prevent cross-site request forgery. Requests without an
+// Origin header, like from curl, are allowed.
+func (ws *WebServer) AllowOrigins(origins []string) {
+	ws.allowedOrigins = origins
+}
+
+// Rejects requests for hosts that aren't allowed.
+func (ws *WebServer) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(ws.allowedHosts) > 0 {
+			host, _, err := net.SplitHostPort(req.Host)
+			if err != nil {
+				// no port
+				host = req.Host
+			}
+			host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+			if !slices.Contains(ws.allowedHosts, host) {
+				ws.logger.Error.Printf("Invalid host %v", req.Host)
+				respondJson(w, http.StatusForbidden, shared.MessageFromWebServer{Status: "invalid host", Results: []any{}})
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Rejects requests from web pages with origins that aren't allowed, and handles CORS for the ones
+// that are. CORS preflight requests are answered here, since they don't include a token.
+func (ws *WebServer) checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !slices.Contains(ws.allowedOrigins, origin) {
+			ws.logger.Error.Printf("Invalid origin %v", origin)
+			respondJson(w, http.StatusForbidden, shared.MessageFromWebServer{Status: "invalid origin", Results: []any{}})
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAgeSecs)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
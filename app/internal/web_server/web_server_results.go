@@ -0,0 +1,107 @@
+package web_server
+
+import (
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+const (
+	ResponseV1 = 1
+	ResponseV2 = 2
+)
+
+// Response to a request, in the version it asked for.
+type response struct {
+	v1 shared.MessageFromWebServer
+	// Set instead of v1 for version 2.
+	v2 *shared.MessageFromWebServerV2
+}
+
+// Returns a response without any results, such as for an error, in the version a request asked for.
+func emptyResponse(msg shared.MessageToWebServer, status string) response {
+	if msg.Version == ResponseV2 {
+		return response{v2: &shared.MessageFromWebServerV2{Status: status, Results: []shared.TabResult{}}}
+	}
+	return response{v1: shared.MessageFromWebServer{Status: status, Results: []any{}}}
+}
+
+func (r response) status() string {
+	if r.v2 != nil {
+		return r.v2.Status
+	}
+	return r.v1.Status
+}
+
+func (r *response) setWaitFor(result *shared.WaitForResult) {
+	if r.v2 != nil {
+		r.v2.WaitFor = result
+	} else {
+		r.v1.WaitFor = result
+	}
+}
+
+// Returns the message to send to the client.
+func (r response) message() any {
+	if r.v2 != nil {
+		return *r.v2
+	}
+	return r.v1
+}
+
+// Returns the message to send over a WebSocket, tagged with the ID of its request.
+func (r response) webSocketMessage(id string) any {
+	if r.v2 != nil {
+		return shared.MessageFromWebSocketV2{Id: id, MessageFromWebServerV2: *r.v2}
+	}
+	return shared.MessageFromWebSocket{Id: id, MessageFromWebServer: r.v1}
+}
+
+// Converts a browser response to the response format requested. Results are values for version 1,
+// or TabResults for version 2. Requests for frames have a value for each frame in version 1, and
+// FrameResults within each TabResult in version 2.
+func buildResponse(msg shared.MessageToWebServer, messageFromBrowser shared.MessageFromBrowser) response {
+	if msg.Version == ResponseV2 {
+		return response{v2: &shared.MessageFromWebServerV2{Status: messageFromBrowser.Status, Results: tabResults(messageFromBrowser)}}
+	}
+
+	if messageFromBrowser.TabResults == nil {
+		return response{v1: shared.MessageFromWebServer{Status: messageFromBrowser.Status, Results: messageFromBrowser.Results}}
+	}
+	// version 1 fails the whole request if any tab or frame fails
+	failed := func(errorMsg string) response {
+		if errorMsg == "" {
+			errorMsg = "error"
+		}
+		return emptyResponse(msg, errorMsg)
+	}
+	results := []any{}
+	for _, tabResult := range messageFromBrowser.TabResults {
+		if tabResult.Status != "ok" {
+			return failed(tabResult.Error)
+		}
+		if tabResult.Frames == nil {
+			results = append(results, tabResult.Value)
+			continue
+		}
+		for _, frameResult := range tabResult.Frames {
+			if frameResult.Status != "ok" {
+				return failed(frameResult.Error)
+			}
+			results = append(results, frameResult.Value)
+		}
+	}
+	return response{v1: shared.MessageFromWebServer{Status: messageFromBrowser.Status, Results: results}}
+}
+
+// Returns the results for each tab, from older versions of the extension too.
+func tabResults(messageFromBrowser shared.MessageFromBrowser) []shared.TabResult {
+	if messageFromBrowser.TabResults != nil {
+		return messageFromBrowser.TabResults
+	}
+	results := []shared.TabResult{}
+	if messageFromBrowser.Status == "ok" {
+		for _, value := range messageFromBrowser.Results {
+			results = append(results, shared.TabResult{Status: "ok", Value: value})
+		}
+	}
+	return results
+}
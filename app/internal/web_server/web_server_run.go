@@ -0,0 +1,77 @@
+package web_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Most bytes a script and its JSON-encoded args may have together.
+const MaxRunSize = 512 * 1024
+
+// Most bytes accepted in the body of POST /run, allowing for escaping in JSON.
+const maxRunBodySize = 2 * MaxRunSize
+
+func validateRunArgs(args shared.RunArgs) error {
+	if strings.TrimSpace(args.Script) == "" {
+		return errors.New("script is required")
+	}
+	size := len(args.Script)
+	// args are passed to the script as an object, so any keys work
+	if args.Args != nil {
+		encodedArgs, err := json.Marshal(args.Args)
+		if err != nil {
+			return fmt.Errorf("args: %w", err)
+		}
+		size += len(encodedArgs)
+	}
+	if size > MaxRunSize {
+		return fmt.Errorf("script and args must be at most %d bytes", MaxRunSize)
+	}
+	return nil
+}
+
+// Runs a script with POST /run, whose body is a RunRequest.
+func (ws *WebServer) HandleRun(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRunBodySize))
+	if err != nil {
+		ws.logger.Error.Printf("Error reading run request: %v", err)
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		respondJson(w, status, shared.MessageFromWebServer{Status: "invalid body: " + err.Error(), Results: []any{}})
+		return
+	}
+	var run shared.RunRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&run)
+	if err != nil {
+		ws.logger.Error.Printf("Error parsing run request: %v", err)
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: "invalid JSON: " + err.Error(), Results: []any{}})
+		return
+	}
+
+	msg := shared.MessageToWebServer{
+		Command: shared.CommandRun,
+		Tabs:    run.Tabs,
+		Frame:   run.Frame,
+		Timeout: run.Timeout,
+		Version: run.Version,
+	}
+	ws.respondToCommand(w, req, msg, run.RunArgs)
+}
@@ -0,0 +1,101 @@
+package web_server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+var imageContentTypes = map[string]string{
+	shared.ImagePng:  "image/png",
+	shared.ImageJpeg: "image/jpeg",
+}
+
+// Responds to GET /screenshot?tab=...&format=...&quality=... with an image of a tab, or the front tab.
+func (ws *WebServer) HandleScreenshot(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+
+	msg, err := screenshotRequest(req)
+	if err == nil {
+		err = ws.validateRequest(msg)
+	}
+	if err != nil {
+		ws.logger.Error.Printf("Invalid screenshot request: %v", err)
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+
+	messageFromBrowser, err := ws.queryBrowser(req.Context(), msg, ws.requestTimeout(msg, DefaultTimeout))
+	if err != nil {
+		if req.Context().Err() != nil {
+			// client has gone away
+			return
+		}
+		respondJson(w, http.StatusInternalServerError, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+	if messageFromBrowser.Status != "ok" {
+		respondJson(w, http.StatusInternalServerError, shared.MessageFromWebServer{Status: messageFromBrowser.Status, Results: []any{}})
+		return
+	}
+	screenshot, image, err := decodeScreenshot(messageFromBrowser)
+	if err != nil {
+		ws.logger.Error.Printf("Invalid screenshot from browser: %v", err)
+		respondJson(w, http.StatusInternalServerError, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+
+	w.Header().Set("Content-Type", imageContentTypes[screenshot.Format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
+
+// Converts the query parameters of a screenshot request to a request for the browser.
+func screenshotRequest(req *http.Request) (shared.MessageToWebServer, error) {
+	msg, err := paramsRequest(req, shared.CommandScreenshot)
+	if err != nil {
+		return msg, err
+	}
+	params := req.URL.Query()
+	args := shared.ScreenshotArgs{Format: params.Get("format")}
+	if param := params.Get("quality"); param != "" {
+		quality, err := strconv.Atoi(param)
+		if err != nil {
+			return msg, fmt.Errorf("invalid quality: %q is not a number", param)
+		}
+		args.Quality = &quality
+	}
+	msg.Args, err = json.Marshal(args)
+	return msg, err
+}
+
+// Returns a screenshot sent by the browser, and its decoded image.
+func decodeScreenshot(messageFromBrowser shared.MessageFromBrowser) (shared.ScreenshotResult, []byte, error) {
+	var screenshot shared.ScreenshotResult
+	if len(messageFromBrowser.Results) != 1 {
+		return screenshot, nil, errors.New("no screenshot received")
+	}
+	data, err := json.Marshal(messageFromBrowser.Results[0])
+	if err != nil {
+		return screenshot, nil, err
+	}
+	err = json.Unmarshal(data, &screenshot)
+	if err != nil {
+		return screenshot, nil, err
+	}
+	if _, ok := imageContentTypes[screenshot.Format]; !ok {
+		return screenshot, nil, fmt.Errorf("unknown image format %q", screenshot.Format)
+	}
+	image, err := base64.StdEncoding.DecodeString(screenshot.Data)
+	return screenshot, image, err
+}
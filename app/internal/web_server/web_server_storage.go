@@ -0,0 +1,39 @@
+package web_server
+
+import (
+	"net/http"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Returns a tab's storage with GET /storage?tab=...&type=..., replaces it with PUT /storage?tab=...,
+// or clears it with DELETE /storage?tab=...&type=...
+func (ws *WebServer) HandleStorage(w http.ResponseWriter, req *http.Request) {
+	var command string
+	switch req.Method {
+	case "GET":
+		command = shared.CommandStorageGet
+	case "PUT":
+		command = shared.CommandStorageSet
+	case "DELETE":
+		command = shared.CommandStorageClear
+	default:
+		ws.logger.Error.Printf("Invalid method %v", req.Method)
+		respondJson(w, http.StatusMethodNotAllowed, shared.MessageFromWebServer{Status: "invalid method", Results: []any{}})
+		return
+	}
+
+	msg, err := paramsRequest(req, command)
+	if err != nil {
+		respondJson(w, http.StatusBadRequest, shared.MessageFromWebServer{Status: err.Error(), Results: []any{}})
+		return
+	}
+	if command == shared.CommandStorageSet {
+		body, ok := ws.readPutBody(w, req)
+		if ok {
+			ws.respondToCommand(w, req, msg, body)
+		}
+		return
+	}
+	ws.respondToCommand(w, req, msg, shared.StorageArgs{Type: req.URL.Query().Get("type")})
+}
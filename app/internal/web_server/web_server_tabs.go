@@ -0,0 +1,77 @@
+package web_server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// Match patterns, as described at https://developer.chrome.com/docs/extensions/develop/concepts/match-patterns
+var matchPatternRegexp = regexp.MustCompile(`^(?:<all_urls>|(?:\*|https?|wss?|ftp)://(?:\*|(?:\*\.)?[^/*:]+)(?::\d+)?/.*|file:///.*)$`)
+
+// Most bytes in a tab selector's title pattern.
+const maxTitleLength = 1024
+
+// Checks that a tab selector can be passed to the browser.
+func validateTabSelector(tabs shared.TabSelector) error {
+	for _, id := range tabs.TabIds {
+		if id < 0 {
+			return fmt.Errorf("invalid tabs: tabIds: %d is negative", id)
+		}
+	}
+	for _, id := range tabs.WindowIds {
+		if id < 0 {
+			return fmt.Errorf("invalid tabs: windowIds: %d is negative", id)
+		}
+	}
+	if tabs.Url != "" && !matchPatternRegexp.MatchString(tabs.Url) {
+		return fmt.Errorf("invalid tabs: url: %q is not a valid match pattern", tabs.Url)
+	}
+	// the browser compiles title as a JavaScript regular expression, and reports it if it's invalid
+	if len(tabs.Title) > maxTitleLength {
+		return fmt.Errorf("invalid tabs: title: must be at most %d bytes", maxTitleLength)
+	}
+	if tabs.Index != nil && *tabs.Index < 0 {
+		return fmt.Errorf("invalid tabs: index: %d is negative", *tabs.Index)
+	}
+	if tabs.Limit < 0 {
+		return fmt.Errorf("invalid tabs: limit: %d is negative", tabs.Limit)
+	}
+	return nil
+}
+
+// Checks that a frame selector can be passed to the browser.
+func validateFrameSelector(frame shared.FrameSelector) error {
+	if frame.All {
+		if frame.FrameIds != nil || frame.Url != "" {
+			return errors.New("invalid frame: all can't be combined with frameIds or url")
+		}
+		return nil
+	}
+	if frame.FrameIds == nil && frame.Url == "" {
+		return errors.New("invalid frame: must select some frames")
+	}
+	for _, id := range frame.FrameIds {
+		if id < 0 {
+			return fmt.Errorf("invalid frame: frameIds: %d is negative", id)
+		}
+	}
+	if frame.Url != "" && !matchPatternRegexp.MatchString(frame.Url) {
+		return fmt.Errorf("invalid frame: url: %q is not a valid match pattern", frame.Url)
+	}
+	return nil
+}
+
+// Returns the tabs a request is sent to, or an empty selector if its command doesn't use tabs.
+func requestTabs(msg shared.MessageToWebServer) shared.TabSelector {
+	if msg.Tabs != nil {
+		return *msg.Tabs
+	}
+	spec, ok := commands[requestCommand(msg)]
+	if !ok || !spec.usesTabs {
+		return shared.TabSelector{}
+	}
+	return spec.defaultTabs()
+}
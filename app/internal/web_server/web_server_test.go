@@ -1,14 +1,19 @@
 package web_server
 
 import (
+	"bufio"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jacobweber/browser_remote/internal/logger"
 	"github.com/jacobweber/browser_remote/internal/shared"
+
+	"github.com/gorilla/websocket"
 )
 
 type TestSenderToBrowser struct {
@@ -59,3 +64,576 @@ func TestWebServer(t *testing.T) {
 		t.Errorf("invalid response received from web server: %v", string(body))
 	}
 }
+
+func sendJobRequest(ws *WebServer, method string, path string, body string) shared.JobFromWebServer {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	ws.ServeHttp(recorder, req)
+	var state shared.JobFromWebServer
+	json.NewDecoder(recorder.Result().Body).Decode(&state)
+	return state
+}
+
+func waitForJob(ws *WebServer, id string, t *testing.T) shared.JobFromWebServer {
+	for range 100 {
+		state := sendJobRequest(ws, http.MethodGet, "/jobs/"+id, "")
+		if state.Status != JobPending {
+			return state
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %v never finished", id)
+	return shared.JobFromWebServer{}
+}
+
+func TestJobs(t *testing.T) {
+	logger := logger.NewStdout()
+	sender := NewTestSenderToBrowser()
+	ws := New(logger)
+	ws.OnMessageReadyForBrowser(func(msg shared.MessageToBrowser) {
+		sender.SendMessage(msg)
+	})
+
+	t.Run("returns results of a job", func(t *testing.T) {
+		started := make(chan shared.JobFromWebServer)
+		go func() {
+			started <- sendJobRequest(ws, http.MethodPost, "/jobs", "{ \"query\": \"name\" }")
+		}()
+		msg := <-sender.messages
+		state := <-started
+		if state.Status != JobPending || state.Id == "" {
+			t.Errorf("invalid job state: %v", state)
+		}
+		if polled := sendJobRequest(ws, http.MethodGet, "/jobs/"+state.Id, ""); polled.Status != JobPending {
+			t.Errorf("expected pending job, got %v", polled)
+		}
+
+		ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: msg.Id, Status: "ok", Results: []any{"john"}})
+		state = waitForJob(ws, state.Id, t)
+		if state.Status != JobDone || len(state.Results) != 1 || state.Results[0] != "john" {
+			t.Errorf("invalid job state: %v", state)
+		}
+	})
+
+	t.Run("returns tab results of a version 2 job", func(t *testing.T) {
+		started := make(chan shared.JobFromWebServer)
+		go func() {
+			started <- sendJobRequest(ws, http.MethodPost, "/jobs", "{ \"query\": \"name\", \"version\": 2 }")
+		}()
+		msg := <-sender.messages
+		state := <-started
+		ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: msg.Id, Status: "ok", TabResults: []shared.TabResult{{TabId: 1, Status: "ok", Value: "john"}}})
+		waitForJob(ws, state.Id, t)
+
+		req := httptest.NewRequest(http.MethodGet, "/jobs/"+state.Id, nil)
+		recorder := httptest.NewRecorder()
+		ws.ServeHttp(recorder, req)
+		var stateV2 shared.JobFromWebServerV2
+		json.NewDecoder(recorder.Result().Body).Decode(&stateV2)
+		if stateV2.Status != JobDone || len(stateV2.Results) != 1 || stateV2.Results[0].TabId != 1 || stateV2.Results[0].Value != "john" {
+			t.Errorf("invalid job state: %v", stateV2)
+		}
+	})
+
+	t.Run("reports browser errors", func(t *testing.T) {
+		started := make(chan shared.JobFromWebServer)
+		go func() {
+			started <- sendJobRequest(ws, http.MethodPost, "/jobs", "{ \"query\": \"name\" }")
+		}()
+		msg := <-sender.messages
+		state := <-started
+		ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: msg.Id, Status: "no tabs found", Results: []any{}})
+		state = waitForJob(ws, state.Id, t)
+		if state.Status != JobFailed || state.Error != "no tabs found" {
+			t.Errorf("invalid job state: %v", state)
+		}
+	})
+
+	t.Run("cancels a job", func(t *testing.T) {
+		started := make(chan shared.JobFromWebServer)
+		go func() {
+			started <- sendJobRequest(ws, http.MethodPost, "/jobs", "{ \"query\": \"name\" }")
+		}()
+		msg := <-sender.messages
+		state := <-started
+		state = sendJobRequest(ws, http.MethodDelete, "/jobs/"+state.Id, "")
+		if state.Status != JobCancelled {
+			t.Errorf("invalid job state: %v", state)
+		}
+		cancelMsg := <-sender.messages
+		if !cancelMsg.Cancel || cancelMsg.Id != msg.Id {
+			t.Errorf("expected cancel message sent to browser, got: %v", cancelMsg)
+		}
+		// late responses from the browser are ignored
+		ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: msg.Id, Status: "ok", Results: []any{"john"}})
+		if polled := sendJobRequest(ws, http.MethodGet, "/jobs/"+state.Id, ""); polled.Status != JobCancelled {
+			t.Errorf("invalid job state: %v", polled)
+		}
+	})
+
+	t.Run("responds to unknown jobs", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/xxx", nil)
+		recorder := httptest.NewRecorder()
+		ws.ServeHttp(recorder, req)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected not found, got %v", recorder.Code)
+		}
+	})
+}
+
+func TestJobStore(t *testing.T) {
+	now := time.Now()
+	store := newJobStore(2, time.Minute)
+	store.now = func() time.Time { return now }
+
+	store.add("a", shared.MessageToWebServer{}, func() {})
+	store.add("b", shared.MessageToWebServer{}, func() {})
+	if _, err := store.add("c", shared.MessageToWebServer{}, func() {}); err != errTooManyJobs {
+		t.Errorf("expected too many jobs, got %v", err)
+	}
+
+	store.finish("a", JobDone, "", nil)
+	if _, err := store.add("c", shared.MessageToWebServer{}, func() {}); err != nil {
+		t.Errorf("expected finished job to be evicted, got %v", err)
+	}
+	if _, ok := store.get("a"); ok {
+		t.Errorf("expected job a to be evicted")
+	}
+
+	store.finish("b", JobDone, "", nil)
+	if _, ok := store.get("b"); !ok {
+		t.Errorf("expected job b to be kept")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := store.get("b"); ok {
+		t.Errorf("expected job b to expire")
+	}
+	if state, ok := store.get("c"); !ok || state.status != JobPending {
+		t.Errorf("expected pending job c to be kept")
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	logger := logger.NewStdout()
+	sender := NewTestSenderToBrowser()
+	ws := New(logger)
+	ws.OnMessageReadyForBrowser(func(msg shared.MessageToBrowser) {
+		sender.SendMessage(msg)
+	})
+	server := httptest.NewServer(http.HandlerFunc(ws.ServeHttp))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("unable to open WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(shared.MessageToWebSocket{Id: "1", MessageToWebServer: shared.MessageToWebServer{Query: "name"}})
+	conn.WriteJSON(shared.MessageToWebSocket{Id: "2", MessageToWebServer: shared.MessageToWebServer{Query: "age"}})
+	queries := map[string]string{}
+	for range 2 {
+		msg := <-sender.messages
+		queries[msg.Query] = msg.Id
+	}
+
+	// respond out of order
+	var resp shared.MessageFromWebSocket
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: queries["age"], Status: "ok", Results: []any{31}})
+	conn.ReadJSON(&resp)
+	if resp.Id != "2" || resp.Status != "ok" || resp.Results[0] != float64(31) {
+		t.Errorf("invalid response received from WebSocket: %v", resp)
+	}
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: queries["name"], Status: "ok", Results: []any{"john"}})
+	conn.ReadJSON(&resp)
+	if resp.Id != "1" || resp.Status != "ok" || resp.Results[0] != "john" {
+		t.Errorf("invalid response received from WebSocket: %v", resp)
+	}
+
+	conn.WriteJSON(shared.MessageToWebSocket{Id: "4", MessageToWebServer: shared.MessageToWebServer{Query: "city", Version: 2}})
+	msg := <-sender.messages
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Id: msg.Id, Status: "ok", TabResults: []shared.TabResult{{TabId: 1, Status: "ok", Value: "Paris"}}})
+	var respV2 shared.MessageFromWebSocketV2
+	conn.ReadJSON(&respV2)
+	if respV2.Id != "4" || respV2.Status != "ok" || len(respV2.Results) != 1 || respV2.Results[0].TabId != 1 || respV2.Results[0].Value != "Paris" {
+		t.Errorf("invalid version 2 response received from WebSocket: %v", respV2)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("{ \"id\": \"3\", \"bad\": true }"))
+	conn.ReadJSON(&resp)
+	if resp.Id != "3" || !strings.HasPrefix(resp.Status, "invalid JSON") {
+		t.Errorf("invalid response received from WebSocket: %v", resp)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	logger := logger.NewStdout()
+	ws := New(logger)
+	server := httptest.NewServer(http.HandlerFunc(ws.ServeHttp))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?types=tab.created,navigation.completed")
+	if err != nil {
+		t.Fatalf("unable to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("invalid content type: %v", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unable to read event stream: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	if event := readEvent(); event != ": connected\n" {
+		t.Errorf("invalid first event: %v", event)
+	}
+
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Event: &shared.BrowserEvent{Type: "tab.created", TabId: 1, WindowId: 2}})
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Event: &shared.BrowserEvent{Type: "tab.removed", TabId: 1, WindowId: 2}})
+	ws.HandleMessageFromBrowser(shared.MessageFromBrowser{Event: &shared.BrowserEvent{Type: "navigation.completed", TabId: 1, Url: "https://www.apple.com/"}})
+
+	if event := readEvent(); event != "event: tab.created\ndata: {\"type\":\"tab.created\",\"tabId\":1,\"windowId\":2}\n" {
+		t.Errorf("invalid event: %v", event)
+	}
+	if event := readEvent(); event != "event: navigation.completed\ndata: {\"type\":\"navigation.completed\",\"tabId\":1,\"url\":\"https://www.apple.com/\"}\n" {
+		t.Errorf("invalid event: %v", event)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	logger := logger.NewStdout()
+	ws := New(logger)
+	ws.RequireToken("secret")
+
+	for _, header := range []string{"", "secret", "Bearer wrong", "Basic secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/xxx", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		recorder := httptest.NewRecorder()
+		ws.ServeHttp(recorder, req)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected unauthorized for %q, got %v", header, recorder.Code)
+		}
+		if recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("expected WWW-Authenticate header for %q", header)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs/xxx", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	ws.ServeHttp(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected authorized request, got %v", recorder.Code)
+	}
+}
+
+func TestHostValidation(t *testing.T) {
+	logger := logger.NewStdout()
+	ws := New(logger)
+	ws.AllowHosts(append([]string{"myhost"}, LocalHosts...))
+
+	for host, expected := range map[string]int{
+		"localhost:5555": http.StatusNotFound,
+		"127.0.0.1:5555": http.StatusNotFound,
+		"[::1]:5555":     http.StatusNotFound,
+		"MyHost":         http.StatusNotFound,
+		"evil.com:5555":  http.StatusForbidden,
+		"evil.com":       http.StatusForbidden,
+		"localhost.evil": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/xxx", nil)
+		req.Host = host
+		recorder := httptest.NewRecorder()
+		ws.ServeHttp(recorder, req)
+		if recorder.Code != expected {
+			t.Errorf("expected %v for host %v, got %v", expected, host, recorder.Code)
+		}
+	}
+}
+
+func TestOriginValidation(t *testing.T) {
+	logger := logger.NewStdout()
+	ws := New(logger)
+	ws.RequireToken("secret")
+	ws.AllowOrigins([]string{"https://good.com"})
+
+	sendRequest := func(method string, origin string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/jobs/xxx", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if authorized {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		recorder := httptest.NewRecorder()
+		ws.ServeHttp(recorder, req)
+		return recorder
+	}
+
+	t.Run("allows requests without an origin", func(t *testing.T) {
+		recorder := sendRequest(http.MethodGet, "", true)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected allowed request, got %v", recorder.Code)
+		}
+		if recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("unexpected CORS header")
+		}
+	})
+
+	t.Run("rejects requests from other origins", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.com", "http://good.com", "null"} {
+			recorder := sendRequest(http.MethodGet, origin, true)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("expected forbidden for %v, got %v", origin, recorder.Code)
+			}
+			recorder = sendRequest(http.MethodOptions, origin, false)
+			if recorder.Code != http.StatusForbidden || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("expected forbidden preflight for %v, got %v", origin, recorder.Code)
+			}
+		}
+	})
+
+	t.Run("answers preflight requests from allowed origins", func(t *testing.T) {
+		recorder := sendRequest(http.MethodOptions, "https://good.com", false)
+		if recorder.Code != http.StatusNoContent {
+			t.Errorf("expected no content, got %v", recorder.Code)
+		}
+		header := recorder.Header()
+		if header.Get("Access-Control-Allow-Origin") != "https://good.com" ||
+			header.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" ||
+			header.Get("Access-Control-Allow-Methods") == "" ||
+			header.Get("Vary") != "Origin" {
+			t.Errorf("invalid preflight headers: %v", header)
+		}
+	})
+
+	t.Run("allows requests from allowed origins", func(t *testing.T) {
+		recorder := sendRequest(http.MethodGet, "https://good.com", true)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected allowed request, got %v", recorder.Code)
+		}
+		if recorder.Header().Get("Access-Control-Allow-Origin") != "https://good.com" {
+			t.Errorf("missing CORS header")
+		}
+		recorder = sendRequest(http.MethodGet, "https://good.com", false)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected unauthorized request, got %v", recorder.Code)
+		}
+	})
+}
+
+func TestTabSelector(t *testing.T) {
+	for _, test := range []struct {
+		json  string
+		valid bool
+	}{
+		{"\"front\"", true},
+		{"\"all\"", true},
+		{"\"back\"", false},
+		{"{\"tabIds\":[1,2],\"windowIds\":[3]}", true},
+		{"{\"tabIds\":[-1]}", false},
+		{"{\"url\":\"<all_urls>\"}", true},
+		{"{\"url\":\"*://*.example.com/*\"}", true},
+		{"{\"url\":\"https://example.com:8080/admin/*\"}", true},
+		{"{\"url\":\"file:///home/*\"}", true},
+		{"{\"url\":\"example.com\"}", false},
+		{"{\"url\":\"https://ex*ample.com/\"}", false},
+		{"{\"title\":\"^Admin.*Dashboard$\"}", true},
+		{"{\"title\":\"(?<=Admin) Dashboard\"}", true},
+		{"{\"title\":\"" + strings.Repeat("a", 1025) + "\"}", false},
+		{"{\"index\":0,\"active\":false,\"pinned\":true,\"audible\":true}", true},
+		{"{\"index\":-1}", false},
+		{"{\"limit\":-1}", false},
+		{"{\"unknown\":true}", false},
+	} {
+		var tabs shared.TabSelector
+		err := json.Unmarshal([]byte(test.json), &tabs)
+		if err == nil {
+			err = validateTabSelector(tabs)
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%v for %v, got %v", test.valid, test.json, err)
+		}
+	}
+}
+
+func TestFrameSelector(t *testing.T) {
+	for _, test := range []struct {
+		json  string
+		valid bool
+	}{
+		{"\"all\"", true},
+		{"0", true},
+		{"[0,3]", true},
+		{"\"*://pay.example.com/*\"", true},
+		{"{\"frameIds\":[2],\"url\":\"https://*/*\"}", true},
+		{"-1", false},
+		{"[1,-1]", false},
+		{"\"pay.example.com\"", false},
+		{"{}", false},
+		{"{\"all\":true,\"frameIds\":[1]}", false},
+		{"{\"unknown\":true}", false},
+		{"true", false},
+	} {
+		var frame shared.FrameSelector
+		err := json.Unmarshal([]byte(test.json), &frame)
+		if err == nil {
+			err = validateFrameSelector(frame)
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%v for %v, got %v", test.valid, test.json, err)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, test := range []struct {
+		json  string
+		valid bool
+	}{
+		{"{\"query\":\"1\"}", true},
+		{"{\"command\":\"eval\",\"query\":\"1\"}", true},
+		{"{\"command\":\"eval\",\"query\":\"1\",\"args\":{}}", false},
+		{"{\"query\":\"1\",\"frame\":\"all\"}", true},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a\"},\"frame\":[1]}", true},
+		{"{\"command\":\"tabs.list\",\"frame\":\"all\"}", false},
+		{"{\"command\":\"tabs.nope\"}", false},
+		{"{\"command\":\"tabs.list\",\"tabs\":{\"windowIds\":[1]}}", true},
+		{"{\"command\":\"tabs.list\",\"query\":\"1\"}", false},
+		{"{\"command\":\"tabs.create\",\"args\":{\"url\":\"https://example.com/\",\"index\":0,\"active\":false}}", true},
+		{"{\"command\":\"tabs.create\",\"args\":{\"url\":\"https://example.com/\"},\"tabs\":\"all\"}", false},
+		{"{\"command\":\"tabs.create\",\"args\":{\"url\":\"\"}}", false},
+		{"{\"command\":\"tabs.create\",\"args\":{\"url\":\"https://example.com/\",\"index\":-1}}", false},
+		{"{\"command\":\"tabs.create\",\"args\":{\"url\":\"https://example.com/\",\"unknown\":1}}", false},
+		{"{\"command\":\"tabs.close\",\"tabs\":{\"tabIds\":[1,2]}}", true},
+		{"{\"command\":\"tabs.close\",\"args\":{\"force\":true}}", false},
+		{"{\"command\":\"tabs.activate\",\"tabs\":{\"tabIds\":[1]}}", true},
+		{"{\"command\":\"tabs.reload\"}", true},
+		{"{\"command\":\"tabs.reload\",\"args\":{\"bypassCache\":true}}", true},
+		{"{\"command\":\"tabs.reload\",\"args\":[]}", false},
+		{"{\"command\":\"tabs.move\",\"args\":{\"index\":-1}}", true},
+		{"{\"command\":\"tabs.move\",\"args\":{\"windowId\":2,\"index\":0}}", true},
+		{"{\"command\":\"tabs.move\",\"args\":{\"windowId\":2}}", false},
+		{"{\"command\":\"tabs.move\",\"args\":{\"index\":-2}}", false},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\"}}", true},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"waitUntil\":\"networkidle\"}}", true},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"waitUntil\":\"selector\",\"selector\":\"#main\"}}", true},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"waitUntil\":\"selector\"}}", false},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"selector\":\"#main\"}}", false},
+		{"{\"command\":\"navigate\",\"args\":{\"url\":\"https://example.com/\",\"waitUntil\":\"idle\"}}", false},
+		{"{\"command\":\"navigate\",\"args\":{}}", false},
+		{"{\"command\":\"screenshot\",\"args\":{\"format\":\"jpeg\",\"quality\":90}}", true},
+		{"{\"command\":\"screenshot\",\"args\":{\"format\":\"jpeg\",\"quality\":101}}", false},
+		{"{\"command\":\"screenshot\",\"args\":{\"format\":\"gif\"}}", false},
+		{"{\"command\":\"cookies.get\",\"args\":{\"url\":\"http://localhost:8080/app\"}}", true},
+		{"{\"command\":\"cookies.get\",\"args\":{\"url\":\"example.com\"}}", false},
+		{"{\"command\":\"cookies.get\",\"args\":{\"url\":\"https://example.com/\"},\"tabs\":\"front\"}", false},
+		{"{\"command\":\"cookies.set\",\"args\":{\"url\":\"https://example.com/\",\"cookies\":[{\"name\":\"a\",\"value\":\"1\",\"expirationDate\":1900000000}]}}", true},
+		{"{\"command\":\"cookies.set\",\"args\":{\"url\":\"https://example.com/\",\"cookies\":[]}}", false},
+		{"{\"command\":\"cookies.set\",\"args\":{\"url\":\"https://example.com/\",\"cookies\":[{\"value\":\"1\"}]}}", false},
+		{"{\"command\":\"cookies.set\",\"args\":{\"url\":\"https://example.com/\",\"cookies\":[{\"name\":\"a\",\"sameSite\":\"none\"}]}}", false},
+		{"{\"command\":\"cookies.remove\",\"args\":{\"url\":\"https://example.com/\",\"name\":\"a\"}}", true},
+		{"{\"command\":\"storage.get\"}", true},
+		{"{\"command\":\"storage.get\",\"args\":{\"type\":\"session\"}}", true},
+		{"{\"command\":\"storage.set\",\"args\":{\"local\":{\"a\":\"1\"}}}", true},
+		{"{\"command\":\"storage.set\",\"args\":{\"local\":{\"a\":1}}}", false},
+		{"{\"command\":\"storage.clear\",\"args\":{\"type\":\"cookies\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"ul > li.item:not([hidden]), a[title='>,']\",\"attributes\":[\"href\",\"data-id\"],\"properties\":[\"value\"],\"limit\":1000}}", true},
+		{"{\"command\":\"dom.query\",\"args\":{\"xpath\":\"//div[@id='main']//a[contains(., 'Next')]\"}}", true},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a\",\"xpath\":\"//a\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"div[id=main\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a,,b\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"ul >\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\".foo\\\\+\"}}", true},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\".foo\\\\\\\\+\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"xpath\":\"//a[@title='C:\\\\']\"}}", true},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a[title='x]\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"xpath\":\"//a[contains(., 'x']\"}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a\",\"attributes\":[\"data id\"]}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a\",\"properties\":[\"style.color\"]}}", false},
+		{"{\"command\":\"dom.query\",\"args\":{\"selector\":\"a\",\"limit\":1001}}", false},
+		{"{\"command\":\"input.click\",\"args\":{\"selector\":\"#submit\",\"button\":\"right\",\"clickCount\":2}}", true},
+		{"{\"command\":\"input.click\",\"args\":{}}", false},
+		{"{\"command\":\"input.click\",\"args\":{\"selector\":\"#submit\",\"clickCount\":4}}", false},
+		{"{\"command\":\"input.type\",\"args\":{\"selector\":\"input[name=q]\",\"text\":\"hello\",\"delay\":50,\"clear\":true}}", true},
+		{"{\"command\":\"input.type\",\"args\":{\"text\":\"hello\"}}", true},
+		{"{\"command\":\"input.type\",\"args\":{\"selector\":\"input\"}}", false},
+		{"{\"command\":\"input.type\",\"args\":{\"text\":\"hello\",\"delay\":-1}}", false},
+		{"{\"command\":\"input.select\",\"args\":{\"selector\":\"select\",\"values\":[\"a\",\"b\"]}}", true},
+		{"{\"command\":\"input.select\",\"args\":{\"selector\":\"select\",\"labels\":[]}}", true},
+		{"{\"command\":\"input.select\",\"args\":{\"selector\":\"select\"}}", false},
+		{"{\"command\":\"input.select\",\"args\":{\"selector\":\"select\",\"values\":[\"a\"],\"labels\":[\"A\"]}}", false},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Control+Shift+K\"}}", true},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Enter\",\"selector\":\"input\"}}", true},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Shift++\"}}", true},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"+\"}}", true},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Control+\"}}", false},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Ctrl+K\"}}", false},
+		{"{\"command\":\"input.press\",\"args\":{\"keys\":\"Shift+Shift+K\"}}", false},
+		{"{\"command\":\"input.press\",\"args\":{}}", false},
+		{"{\"command\":\"input.scroll\",\"args\":{\"selector\":\"#footer\",\"block\":\"end\"}}", true},
+		{"{\"command\":\"input.scroll\",\"args\":{\"y\":500}}", true},
+		{"{\"command\":\"input.scroll\",\"args\":{}}", false},
+		{"{\"command\":\"input.scroll\",\"args\":{\"selector\":\"#footer\",\"y\":500}}", false},
+		{"{\"command\":\"input.scroll\",\"args\":{\"block\":\"end\",\"y\":500}}", false},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"const a = await x();\\nreturn a;\",\"args\":{\"id\":1,\"$name\":\"x\"}},\"frame\":\"all\"}", true},
+		{"{\"command\":\"run\",\"args\":{\"script\":\" \"}}", false},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"return args['user-id']\",\"args\":{\"user-id\":1,\"1st\":2}}}", true},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"return 1\",\"args\":[1]}}", false},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"" + strings.Repeat("x", MaxRunSize) + "\"}}", true},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"" + strings.Repeat("x", MaxRunSize-6) + "\",\"args\":{\"a\":1}}}", false},
+		{"{\"query\":\"fetch('/')\",\"awaitPromise\":true,\"waitFor\":{}}", true},
+		{"{\"command\":\"run\",\"args\":{\"script\":\"return 1\"},\"awaitPromise\":true}", false},
+	} {
+		var msg shared.MessageToWebServer
+		err := json.Unmarshal([]byte(test.json), &msg)
+		if err == nil {
+			err = validateCommand(msg)
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("expected valid=%v for %v, got %v", test.valid, test.json, err)
+		}
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	for _, test := range []struct {
+		json   string
+		truthy bool
+	}{
+		{"null", false},
+		{"false", false},
+		{"0", false},
+		{"\"\"", false},
+		{"true", true},
+		{"-1", true},
+		{"\"0\"", true},
+		{"[]", true},
+		{"{}", true},
+	} {
+		var value any
+		err := json.Unmarshal([]byte(test.json), &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isTruthy(value) != test.truthy {
+			t.Errorf("expected truthy=%v for %v", test.truthy, test.json)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package web_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+)
+
+// How long to wait between attempts of a WaitFor request, unless it specifies an interval.
+const DefaultWaitForInterval = 100 * time.Millisecond
+
+// Checks that a request's WaitFor options are valid.
+func validateWaitFor(msg shared.MessageToWebServer) error {
+	if msg.WaitFor == nil {
+		return nil
+	}
+	if requestCommand(msg) != shared.CommandEval {
+		return fmt.Errorf("invalid waitFor: not used by %v", requestCommand(msg))
+	}
+	if msg.WaitFor.Interval < 0 {
+		return errors.New("invalid waitFor: interval must not be negative")
+	}
+	return nil
+}
+
+// Returns how long to wait between attempts of a WaitFor request.
+func waitForInterval(msg shared.MessageToWebServer) time.Duration {
+	if msg.WaitFor.Interval > 0 {
+		return time.Duration(msg.WaitFor.Interval * float64(time.Second))
+	}
+	return DefaultWaitForInterval
+}
+
+// Runs a query repeatedly until it returns a truthy value in every tab, and returns its last response,
+// or a timeout error if that doesn't happen within timeout. If the query fails in the browser, returns
+// its error without retrying. Either way, the response reports how many attempts were made.
+func (ws *WebServer) waitFor(ctx context.Context, msg shared.MessageToWebServer, timeout time.Duration) (response, error) {
+	timer := timerFrom(ctx)
+	interval := waitForInterval(msg)
+	start := timer.Now()
+	deadline := start.Add(timeout)
+	result := &shared.WaitForResult{}
+
+	failed := func(err error) (response, error) {
+		result.Elapsed = timer.Now().Sub(start).Seconds()
+		response := emptyResponse(msg, err.Error())
+		response.setWaitFor(result)
+		return response, err
+	}
+
+	for {
+		result.Attempts++
+		messageFromBrowser, err := ws.queryBrowser(ctx, msg, deadline.Sub(timer.Now()))
+		if err != nil {
+			return failed(err)
+		}
+		if isTruthyResponse(messageFromBrowser) || isFailedResponse(messageFromBrowser) {
+			response := buildResponse(msg, messageFromBrowser)
+			result.Elapsed = timer.Now().Sub(start).Seconds()
+			response.setWaitFor(result)
+			return response, nil
+		}
+
+		remaining := deadline.Sub(timer.Now())
+		if remaining <= 0 {
+			return failed(errTimeout)
+		}
+		ws.logger.Trace.Printf("Waiting %v to retry query, after %v attempts", min(interval, remaining), result.Attempts)
+		select {
+		case <-timer.StartTimer(min(interval, remaining)):
+		case <-ctx.Done():
+			return failed(ctx.Err())
+		}
+		if interval >= remaining {
+			return failed(errTimeout)
+		}
+	}
+}
+
+// Returns whether a browser response succeeded in every tab or frame, with values JavaScript considers true.
+func isTruthyResponse(messageFromBrowser shared.MessageFromBrowser) bool {
+	if messageFromBrowser.Status != "ok" {
+		return false
+	}
+	results := tabResults(messageFromBrowser)
+	if len(results) == 0 {
+		return false
+	}
+	for _, result := range results {
+		if result.Status != "ok" {
+			return false
+		}
+		if result.Frames == nil {
+			if !isTruthy(result.Value) {
+				return false
+			}
+			continue
+		}
+		if len(result.Frames) == 0 {
+			return false
+		}
+		for _, frameResult := range result.Frames {
+			if frameResult.Status != "ok" || !isTruthy(frameResult.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns whether a browser response failed as a whole, or in any tab or frame.
+func isFailedResponse(messageFromBrowser shared.MessageFromBrowser) bool {
+	if messageFromBrowser.Status != "ok" {
+		return true
+	}
+	for _, result := range messageFromBrowser.TabResults {
+		if result.Status != "ok" {
+			return true
+		}
+		for _, frameResult := range result.Frames {
+			if frameResult.Status != "ok" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns whether a JSON value is truthy in JavaScript.
+func isTruthy(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return value
+	case float64:
+		return value != 0
+	case string:
+		return value != ""
+	default:
+		return true
+	}
+}
@@ -0,0 +1,92 @@
+package web_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/jacobweber/browser_remote/internal/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// Maximum size of a query sent over a WebSocket.
+const maxWebSocketMessageSize = 1024 * 1024
+
+var upgrader = websocket.Upgrader{
+	// origins were already checked by checkOrigin
+	CheckOrigin: func(req *http.Request) bool { return true },
+}
+
+// Accepts queries over a WebSocket with GET /ws. Each query is tagged with a client-chosen ID,
+// which is included in its response. Queries run concurrently, and responses are sent as soon as
+// they're ready, so they may arrive out of order.
+func (ws *WebServer) HandleWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// upgrader has already responded with an error
+		ws.logger.Error.Printf("Unable to open WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxWebSocketMessageSize)
+	ws.logger.Trace.Printf("Opened WebSocket")
+
+	// cancel pending queries once the client disconnects
+	ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		ws.logger.Trace.Printf("Closed WebSocket")
+	}()
+
+	// gorilla/websocket allows only one concurrent writer
+	var writeMutex sync.Mutex
+	respond := func(msg any) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		err := conn.WriteJSON(msg)
+		if err != nil {
+			ws.logger.Error.Printf("Unable to write to WebSocket: %v", err)
+		}
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				ws.logger.Error.Printf("Error reading from WebSocket: %v", err)
+			}
+			return
+		}
+
+		var msg shared.MessageToWebSocket
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&msg)
+		if err != nil {
+			ws.logger.Error.Printf("Error parsing WebSocket message: %v", err)
+			respond(shared.MessageFromWebSocket{Id: msg.Id, MessageFromWebServer: shared.MessageFromWebServer{Status: "invalid JSON: " + err.Error(), Results: []any{}}})
+			continue
+		}
+		err = ws.validateRequest(msg.MessageToWebServer)
+		if err != nil {
+			ws.logger.Error.Printf("Invalid WebSocket message: %v", err)
+			respond(emptyResponse(msg.MessageToWebServer, err.Error()).webSocketMessage(msg.Id))
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := ws.runRequest(ctx, msg.MessageToWebServer, ws.requestTimeout(msg.MessageToWebServer, DefaultTimeout))
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			respond(response.webSocketMessage(msg.Id))
+		}()
+	}
+}